@@ -1,3 +1,8 @@
 module easyCopy
 
 go 1.24.11
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+)
@@ -1,361 +1,3945 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/big"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
+	"unicode"
+
+	"github.com/gorilla/websocket"
+	"github.com/skip2/go-qrcode"
 )
 
 const VERSION = "0.260212.4"
 
+var (
+	popPinned          = flag.Bool("pop-pinned", false, "允许通过 /api/pop 取出并删除置顶项（默认置顶项受保护）")
+	rateLimit          = flag.Float64("rate", 0, "修改类接口每秒允许的请求数，0 表示不限制")
+	normalizeNewlines  = flag.Bool("normalize-newlines", false, "添加前将 CRLF 换行统一为 LF，再进行去重和存储")
+	sanitize           = flag.Bool("sanitize", false, "添加前移除 NUL 等不可打印的控制字符（保留制表符和换行符）")
+	trimTrailing       = flag.Bool("trim", false, "添加前去除每行末尾的空白和内容末尾的空行")
+	collapseBlanks     = flag.Bool("collapse-blanks", false, "添加前将连续的多个空行合并为一个")
+	maxNamespaces      = flag.Int("max-namespaces", 100, "最多允许的 /u/<name>/ 命名空间数量，0 表示禁用命名空间")
+	maxPinned          = flag.Int("max-pinned", 0, "最多允许置顶的条目数，0 表示不限制")
+	separatePinned     = flag.Bool("separate-pinned", false, "粘贴与置顶项相同的内容时新建一条非置顶记录，而不是忽略这次粘贴")
+	keepHistory        = flag.Bool("keep-history", false, "修改条目内容时保留历史版本（每条最多 10 个）")
+	maxBodySize        = flag.Int64("max-body", 8<<20, "POST 请求体的最大字节数，超出返回 413")
+	openBrowserOnStart = flag.Bool("open", false, "启动后自动用默认浏览器打开页面")
+	authUser           = flag.String("user", "", "HTTP Basic 认证的用户名，需与 -pass 同时设置")
+	authPass           = flag.String("pass", "", "HTTP Basic 认证的密码，需与 -user 同时设置")
+	listenAddr         = flag.String("addr", "", "监听地址，如 :8084 或 127.0.0.1:9000；未设置时依次读取环境变量 EASYCOPY_ADDR、PORT，默认 :8084")
+	basePathFlag       = flag.String("base-path", "", "所有页面和接口的路径前缀，用于在反向代理的子路径下运行，如 /clipboard")
+	useTLS             = flag.Bool("tls", true, "使用自签名证书提供 HTTPS，关闭后浏览器会禁止页面读写剪贴板")
+	certOrg            = flag.String("cert-org", "Clipboard Manager", "自签名证书的组织名称（O）")
+	certCN             = flag.String("cert-cn", "", "自签名证书的通用名称（CN），用于在浏览器中区分多个实例")
+	clientCAPath       = flag.String("client-ca", "", "CA 证书文件（PEM），设置后只接受持有该 CA 签发的客户端证书的连接")
+	auditLogPath       = flag.String("audit-log", "", "将所有修改操作以 JSON 行追加写入该文件（只记录内容的哈希）")
+	webhookURL         = flag.String("webhook-url", "", "新增条目后向该地址 POST 通知（JSON）")
+	compressData       = flag.Bool("compress", false, "使用 gzip 压缩数据文件（读取时自动识别是否压缩）")
+	noPersist          = flag.Bool("no-persist", false, "只在内存中保存数据，不读写数据文件，重启后清空")
+	refreshInterval    = flag.Duration("refresh-interval", 2*time.Second, "前端自动刷新的间隔")
+	maxAge             = flag.Duration("max-age", 0, "自动删除创建时间超过该时长的非置顶条目，如 720h，0 表示不限制")
+	truncateLength     = flag.Int("truncate-length", 1000, "前端超过该字符数的内容会被折叠")
+)
+
 type ClipboardItem struct {
-	ID      int    `json:"id"`
-	Content string `json:"content"`
-	Pinned  bool   `json:"pinned"`
+	ID        int        `json:"id"`
+	Content   string     `json:"content"`
+	Pinned    bool       `json:"pinned"`
+	Kind      string     `json:"kind"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MimeType  string     `json:"mime,omitempty"`       // 非空时 Content 为 base64 编码的二进制数据
+	History   []string   `json:"history,omitempty"`    // 修改前的历史内容，最新的在最后
+	Archived  bool       `json:"archived"`             // 归档的条目不在主列表中显示
+	Source    string     `json:"source,omitempty"`     // 内容来源，如设备名称或客户端 IP
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // 仅回收站中的条目有值
+	CreatedAt time.Time  `json:"created_at"`           // 首次添加的时间，重新粘贴时保持不变
+	LastSeen  time.Time  `json:"last_seen"`            // 最近一次添加或重新粘贴的时间
+	CopyCount int        `json:"copy_count"`           // 该内容被粘贴的次数，首次添加时为 1
+
+	seq int64 // 在列表中的排序键，只在内存中使用，见 ClipboardManager.idIndex
+}
+
+// mergeRepaste 重新粘贴已存在的内容时更新条目，所有合并路径都经过这里以保证语义一致：
+// LastSeen 更新为 now，CopyCount 加一，source 非空时更新来源，归档的条目恢复到主列表；
+// CreatedAt、Pinned、ID 和历史版本保持不变
+func (item *ClipboardItem) mergeRepaste(now time.Time, source string) {
+	item.LastSeen = now
+	item.CopyCount++
+	if source != "" {
+		item.Source = source
+	}
+	item.Archived = false
+}
+
+// mergeDuplicate 将同内容的 other 合并到 item 中（Compact 使用）：
+// 粘贴次数相加，CreatedAt 取较早者，LastSeen 取较晚者，任一条置顶则结果为置顶
+func (item *ClipboardItem) mergeDuplicate(other ClipboardItem) {
+	item.CopyCount += other.CopyCount
+	if other.CreatedAt.Before(item.CreatedAt) {
+		item.CreatedAt = other.CreatedAt
+	}
+	if other.LastSeen.After(item.LastSeen) {
+		item.LastSeen = other.LastSeen
+	}
+	item.Pinned = item.Pinned || other.Pinned
+}
+
+// fillTimestamps 为缺少新字段的旧数据或导入数据补全默认值：
+// 没有创建时间时视为在 now 创建，没有 LastSeen 时使用创建时间，粘贴次数至少为 1
+func (item *ClipboardItem) fillTimestamps(now time.Time) {
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = now
+	}
+	if item.LastSeen.IsZero() {
+		item.LastSeen = item.CreatedAt
+	}
+	if item.CopyCount < 1 {
+		item.CopyCount = 1
+	}
+}
+
+// 内容类型
+const (
+	KindURL      = "url"
+	KindEmail    = "email"
+	KindHexColor = "hex-color"
+	KindJSON     = "json"
+	KindText     = "text"
+	KindBlob     = "blob"
+)
+
+// maxHistoryLength 每个条目最多保留的历史版本数
+const maxHistoryLength = 10
+
+// maxBlobSize 二进制条目（如图片）解码后的最大字节数
+const maxBlobSize = 5 << 20
+
+// maxPreviewLength 预览模式下 maxlen 参数允许的最大值
+const maxPreviewLength = 100000
+
+// maxTrashSize 回收站最多保留的条目数，超出时丢弃最早删除的条目
+const maxTrashSize = 100
+
+// validMimeType 判断 value 是否为合法的 MIME 类型
+// mime 字段原样写入以 | 分隔、按行存储的数据文件，因此额外拒绝 |、逗号和控制字符
+func validMimeType(value string) bool {
+	if strings.ContainsAny(value, "|,") || strings.ContainsFunc(value, unicode.IsControl) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(value)
+	return err == nil && strings.Contains(mediaType, "/")
+}
+
+// maxTTLSeconds ttl_seconds 允许的最大值（10 年）
+const maxTTLSeconds = 10 * 365 * 24 * 60 * 60
+
+// maxSourceLength 来源字段的最大字符数
+const maxSourceLength = 64
+
+var (
+	urlPattern      = regexp.MustCompile(`^https?://\S+$`)
+	emailPattern    = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+	hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+)
+
+// detectKind 根据简单规则判断内容类型，无法识别时返回 text
+func detectKind(content string) string {
+	trimmed := strings.TrimSpace(content)
+	switch {
+	case urlPattern.MatchString(trimmed):
+		return KindURL
+	case emailPattern.MatchString(trimmed):
+		return KindEmail
+	case hexColorPattern.MatchString(trimmed):
+		return KindHexColor
+	case (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)):
+		return KindJSON
+	}
+	return KindText
+}
+
+// 变更事件类型
+const (
+	EventSnapshot = "snapshot"
+	EventAdded    = "added"
+	EventMoved    = "moved"
+	EventUpdated  = "updated"
+	EventDeleted  = "deleted"
+)
+
+// ChangeEvent 描述一次列表变更，推送给订阅者
+// added/moved 表示条目被放到最前面，updated 表示条目原地更新，deleted 只携带 ID
+// 调整置顶顺序时推送 snapshot，携带完整列表
+// Revision 为该变更之后的列表版本号
+type ChangeEvent struct {
+	Type     string          `json:"type"`
+	Item     *ClipboardItem  `json:"item,omitempty"`
+	ID       int             `json:"id,omitempty"`
+	Items    []ClipboardItem `json:"items,omitempty"`
+	Revision int64           `json:"revision"`
+}
+
+// subscriberBuffer 每个订阅者可积压的事件数，超出后订阅会被关闭
+const subscriberBuffer = 64
+
+// normalizeText 依次执行 transformers 处理文本内容，添加和按内容查找时使用同样的规则
+// 没有配置任何处理步骤时原样返回
+func normalizeText(content string) string {
+	for _, transform := range transformers {
+		content = transform(content)
+	}
+	return content
+}
+
+// transformers 添加文本前依次执行的处理步骤，由 buildTransformers 根据命令行参数生成
+var transformers []func(string) string
+
+// buildTransformers 按固定顺序组装处理链：换行规范化、清理控制字符、-replace 规则（按参数顺序）、合并空行、去除行尾空白
+// 先替换再整理空白，使替换产生的空行和行尾空格也能被清理
+func buildTransformers() []func(string) string {
+	var chain []func(string) string
+	if *normalizeNewlines {
+		chain = append(chain, func(s string) string { return strings.ReplaceAll(s, "\r\n", "\n") })
+	}
+	if *sanitize {
+		chain = append(chain, sanitizeContent)
+	}
+	for _, rule := range replaceFlag {
+		chain = append(chain, rule.apply)
+	}
+	if *collapseBlanks {
+		chain = append(chain, collapseBlankLines)
+	}
+	if *trimTrailing {
+		chain = append(chain, trimTrailingSpace)
+	}
+	return chain
+}
+
+// replaceRule 一条 -replace 规则，repl 中可以使用 $1 等引用分组
+type replaceRule struct {
+	pattern *regexp.Regexp
+	repl    string
+}
+
+func (rule replaceRule) apply(s string) string {
+	return rule.pattern.ReplaceAllString(s, rule.repl)
+}
+
+// replaceRules 实现 flag.Value，-replace 可以重复指定
+type replaceRules []replaceRule
+
+var replaceFlag replaceRules
+
+func (rules *replaceRules) String() string {
+	parts := make([]string, len(*rules))
+	for i, rule := range *rules {
+		parts[i] = rule.pattern.String() + "=>" + rule.repl
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Set 解析 'pat=>repl' 形式的规则，正则表达式无效时返回错误
+func (rules *replaceRules) Set(value string) error {
+	pat, repl, ok := strings.Cut(value, "=>")
+	if !ok {
+		return errors.New("格式应为 'pat=>repl'")
+	}
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		return err
+	}
+	*rules = append(*rules, replaceRule{pattern: re, repl: repl})
+	return nil
+}
+
+// blankLinesPattern 匹配两个及以上连续的空行（只含空格和制表符的行也算空行）
+var blankLinesPattern = regexp.MustCompile(`(\r?\n)[ \t]*\r?\n(?:[ \t]*\r?\n)+`)
+
+// collapseBlankLines 将连续的多个空行合并为一个
+func collapseBlankLines(s string) string {
+	return blankLinesPattern.ReplaceAllString(s, "$1$1")
+}
+
+// trailingSpacePattern 匹配每行末尾的空格和制表符
+var trailingSpacePattern = regexp.MustCompile(`(?m)[ \t]+(\r?)$`)
+
+// trimTrailingSpace 去除每行末尾的空白以及内容末尾的空行
+func trimTrailingSpace(s string) string {
+	s = trailingSpacePattern.ReplaceAllString(s, "$1")
+	return strings.TrimRight(s, "\r\n")
+}
+
+// sanitizeContent 移除除制表符和换行符以外的控制字符
+func sanitizeContent(content string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, content)
 }
 
 type ClipboardManager struct {
-	items  []ClipboardItem
-	nextID int
-	mu     sync.RWMutex
+	// items 按最近使用排序，新条目在前；显示时只是把置顶项整体提到前面，其余相对顺序不变
+	// 保存时按 orderedItems 的顺序写入，因此重新加载后显示顺序与保存前完全一致
+	items      []ClipboardItem
+	nextID     int
+	revision   int64     // 每次变更递增，用于客户端判断列表是否有更新
+	modifiedAt time.Time // 最近一次变更的时间，与 revision 一起更新，作为 /api/items 的 Last-Modified
+	dataFile   string
+	mu         sync.RWMutex
+	saveMu     sync.Mutex // 串行化文件写入，与数据锁分开以免写盘时阻塞读取
+
+	// dirty 上次保存后数据是否有变化；savedHash 为上次写入内容的哈希，由 saveMu 保护
+	// 二者配合让没有实际变化的保存（如连续两次切换置顶）不再重写文件
+	dirty     atomic.Bool
+	savedHash [sha256.Size]byte
+	hasSaved  bool
+
+	// contentIndex 内容哈希到所有该内容条目 ID 的映射，用于 O(1) 去重查找，所有修改列表的操作都必须同步维护
+	// 通过修改、追加或恢复可能产生内容相同的多个条目，删除其中一个后索引仍能找到其余的条目
+	contentIndex map[string][]int
+	// idIndex 条目 ID 到 seq 的映射；items 按 seq 从大到小排列，按 ID 查找时二分查找 seq
+	// 插入和删除不改变其他条目的 seq，因此每次修改只需更新一项索引
+	idIndex map[int]int64
+	nextSeq int64 // 下一个插入到最前面的条目使用的 seq
+	// trash 被删除的条目，最近删除的在前，不参与去重和 ID 索引
+	trash []ClipboardItem
+
+	subscribers map[chan ChangeEvent]struct{}
+	subMu       sync.Mutex
 }
 
-func NewClipboardManager() *ClipboardManager {
+// NewClipboardManager 创建使用 dataFile 作为数据文件的管理器
+func NewClipboardManager(dataFile string) *ClipboardManager {
 	return &ClipboardManager{
-		items:  make([]ClipboardItem, 0),
-		nextID: 1,
+		items:        make([]ClipboardItem, 0),
+		nextID:       1,
+		modifiedAt:   time.Now(),
+		dataFile:     dataFile,
+		contentIndex: make(map[string][]int),
+		idIndex:      make(map[int]int64),
+		subscribers:  make(map[chan ChangeEvent]struct{}),
+	}
+}
+
+// contentKey 返回用于去重的内容哈希，不同 MIME 类型的相同内容视为不同条目
+func contentKey(content, mime string) string {
+	h := sha256.New()
+	h.Write([]byte(mime))
+	h.Write([]byte{0})
+	h.Write([]byte(content))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// indexContent 将条目加入内容索引，调用方须持有写锁
+func (cm *ClipboardManager) indexContent(item ClipboardItem) {
+	key := contentKey(item.Content, item.MimeType)
+	cm.contentIndex[key] = append(cm.contentIndex[key], item.ID)
+}
+
+// unindexContent 将条目移出内容索引，同内容的其他条目仍保留在索引中，调用方须持有写锁
+func (cm *ClipboardManager) unindexContent(item ClipboardItem) {
+	key := contentKey(item.Content, item.MimeType)
+	ids := slices.DeleteFunc(cm.contentIndex[key], func(id int) bool { return id == item.ID })
+	if len(ids) == 0 {
+		delete(cm.contentIndex, key)
+	} else {
+		cm.contentIndex[key] = ids
+	}
+}
+
+// rebuildContentIndex 根据当前列表重建内容索引，调用方须持有写锁
+func (cm *ClipboardManager) rebuildContentIndex() {
+	cm.contentIndex = make(map[string][]int, len(cm.items))
+	for _, item := range cm.items {
+		cm.indexContent(item)
+	}
+}
+
+// findContent 返回内容与 content 相同的条目中在 items 里最靠前的下标，unpinnedOnly 时只查找非置顶条目
+// 调用方须持有锁
+func (cm *ClipboardManager) findContent(content, mime string, unpinnedOnly bool) (int, bool) {
+	best, found := 0, false
+	for _, id := range cm.contentIndex[contentKey(content, mime)] {
+		i, ok := cm.indexOf(id)
+		if !ok || unpinnedOnly && cm.items[i].Pinned {
+			continue
+		}
+		if !found || i < best {
+			best, found = i, true
+		}
 	}
+	return best, found
 }
 
+// indexOf 返回 ID 对应条目在 items 中的下标，调用方须持有锁
+func (cm *ClipboardManager) indexOf(id int) (int, bool) {
+	seq, ok := cm.idIndex[id]
+	if !ok {
+		return 0, false
+	}
+	return slices.BinarySearchFunc(cm.items, seq, func(item ClipboardItem, seq int64) int {
+		return cmp.Compare(seq, item.seq)
+	})
+}
+
+// rebuildIDIndex 按当前顺序重新分配 seq 并重建 ID 索引，在加载、批量删除或调整顺序后调用，调用方须持有写锁
+func (cm *ClipboardManager) rebuildIDIndex() {
+	cm.idIndex = make(map[int]int64, len(cm.items))
+	n := int64(len(cm.items))
+	for i := range cm.items {
+		cm.items[i].seq = n - int64(i)
+		cm.idIndex[cm.items[i].ID] = cm.items[i].seq
+	}
+	cm.nextSeq = n + 1
+}
+
+// removeAt 删除下标 i 处的条目并同步两个索引，调用方须持有写锁
+func (cm *ClipboardManager) removeAt(i int) ClipboardItem {
+	item := cm.items[i]
+	cm.items = slices.Delete(cm.items, i, i+1)
+	delete(cm.idIndex, item.ID)
+	cm.unindexContent(item)
+	return item
+}
+
+// prepend 将条目插入到列表最前面并更新 ID 索引，调用方须持有写锁
+func (cm *ClipboardManager) prepend(item ClipboardItem) {
+	item.seq = cm.nextSeq
+	cm.nextSeq++
+	cm.items = slices.Insert(cm.items, 0, item)
+	cm.idIndex[item.ID] = item.seq
+}
+
+// Subscribe 返回当前列表的快照事件和后续变更事件的通道
+// 快照与订阅在同一把读锁内完成，保证不会漏掉事件；调用 cancel 取消订阅
+// 如果订阅者处理过慢导致积压，通道会被关闭，订阅者应重新订阅以获取新快照
+func (cm *ClipboardManager) Subscribe() (ChangeEvent, <-chan ChangeEvent, func()) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	ch := make(chan ChangeEvent, subscriberBuffer)
+	cm.subMu.Lock()
+	cm.subscribers[ch] = struct{}{}
+	cm.subMu.Unlock()
+
+	cancel := func() {
+		cm.subMu.Lock()
+		defer cm.subMu.Unlock()
+		if _, ok := cm.subscribers[ch]; ok {
+			delete(cm.subscribers, ch)
+			close(ch)
+		}
+	}
+	snapshot := ChangeEvent{
+		Type:     EventSnapshot,
+		Items:    cm.displayItems(),
+		Revision: cm.revision,
+	}
+	return snapshot, ch, cancel
+}
+
+// notifyChange 递增版本号并向所有订阅者广播事件
+// 所有修改列表的操作都必须调用它，调用方须持有写锁以保证事件顺序
+func (cm *ClipboardManager) notifyChange(event ChangeEvent) {
+	cm.dirty.Store(true)
+	cm.revision++
+	cm.modifiedAt = time.Now()
+	event.Revision = cm.revision
+
+	cm.subMu.Lock()
+	defer cm.subMu.Unlock()
+
+	for ch := range cm.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(cm.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// AddItem 添加文本条目，处理后内容为空时不添加并返回零值
 func (cm *ClipboardManager) AddItem(content string) (ClipboardItem, bool) {
+	item, existed, _ := cm.AddItemFrom(content, "", "")
+	return item, existed
+}
+
+// AddItemWithMime 添加条目，mime 非空时 content 为 base64 编码的二进制数据
+func (cm *ClipboardManager) AddItemWithMime(content, mime string) (ClipboardItem, bool) {
+	item, existed, _ := cm.AddItemFrom(content, mime, "")
+	return item, existed
+}
+
+// errContentEmpty 文本经过 -trim、-sanitize 等处理后为空，不会被添加
+var errContentEmpty = errors.New("处理后内容为空")
+
+// AddItemFrom 添加条目并记录来源，已存在的内容被重新添加时按 mergeRepaste 合并
+// 内容已存在时移到最前面；与置顶项相同时只原地更新，不改变顺序，开启 -separate-pinned 时新建非置顶条目
+// 二进制数据不做换行规范化和控制字符清理；文本处理后为空时返回 errContentEmpty
+func (cm *ClipboardManager) AddItemFrom(content, mime, source string) (ClipboardItem, bool, error) {
+	// 存储规范化后的内容，保证之后的比较结果稳定
+	if mime == "" {
+		content = normalizeText(content)
+	}
+	if content == "" {
+		return ClipboardItem{}, false, errContentEmpty
+	}
+
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	// 检查是否已存在相同内容
-	for i, item := range cm.items {
-		if item.Content == content {
-			// 如果已置顶，保持不动，直接返回
+	// 通过内容哈希检查是否已存在相同内容
+	if i, ok := cm.findContent(content, mime, false); ok {
+		// -separate-pinned 时置顶项不参与去重，改为查找相同内容的非置顶副本，没有则新建
+		if cm.items[i].Pinned && *separatePinned {
+			i, ok = cm.findContent(content, mime, true)
+		}
+		if ok {
+			item := cm.items[i]
+			item.mergeRepaste(time.Now(), source)
+			// 如果已置顶，保持位置不动
 			if item.Pinned {
-				return item, true
+				cm.items[i] = item
+				cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &item})
+				return item, true, nil
 			}
 			// 从原位置移除
-			cm.items = append(cm.items[:i], cm.items[i+1:]...)
+			cm.removeAt(i)
 			// 插入到最前面（显示时会排在置顶项之后）
-			cm.items = append([]ClipboardItem{item}, cm.items...)
-			return item, true
+			cm.prepend(item)
+			cm.indexContent(item)
+			cm.notifyChange(ChangeEvent{Type: EventMoved, Item: &item})
+			return item, true, nil
 		}
 	}
 
+	kind := KindBlob
+	if mime == "" {
+		kind = detectKind(content)
+	}
+	now := time.Now()
 	item := ClipboardItem{
-		ID:      cm.nextID,
-		Content: content,
-		Pinned:  false,
+		ID:        cm.nextID,
+		Content:   content,
+		Pinned:    false,
+		Kind:      kind,
+		MimeType:  mime,
+		Source:    source,
+		CreatedAt: now,
+		LastSeen:  now,
+		CopyCount: 1,
 	}
 	cm.nextID++
-	cm.items = append([]ClipboardItem{item}, cm.items...)
-	return item, false
+	cm.prepend(item)
+	cm.indexContent(item)
+	cm.notifyChange(ChangeEvent{Type: EventAdded, Item: &item})
+	if *webhookURL != "" {
+		go sendWebhook(*webhookURL, item)
+	}
+	return item, false, nil
+}
+
+// ExportItems 返回所有条目（包含已归档的条目，不包含回收站），顺序与保存到文件时一致
+func (cm *ClipboardManager) ExportItems() []ClipboardItem {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	return cm.orderedItems()
+}
+
+// ImportItems 将其他实例导出的条目合并到列表中，返回新增、因内容重复而跳过和未通过校验的数量
+// 导入的条目分配新的 ID，保留置顶、归档、来源和创建时间，按原顺序放在列表最前面
+// 去重规则与 AddItem 相同；内容为空、二进制条目未通过 validateBlob 的条目计入 invalid，与 /api/add 拒绝的数据一致
+// 来源按 maxSourceLength 截断；置顶数量已达上限时以非置顶状态导入
+func (cm *ClipboardManager) ImportItems(items []ClipboardItem) (added, skipped, invalid int) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	now := time.Now()
+	// 倒序插入，使导入列表中的第一条最终排在最前面
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.MimeType == "" {
+			item.Content = normalizeText(item.Content)
+		}
+		if item.Content == "" || item.MimeType != "" && validateBlob(item.Content, item.MimeType) != nil {
+			invalid++
+			continue
+		}
+		if cm.hasDuplicate(item.Content, item.MimeType) {
+			skipped++
+			continue
+		}
+		item.Source, _ = truncateRunes(item.Source, maxSourceLength)
+
+		item.ID = cm.nextID
+		cm.nextID++
+		item.Kind = KindBlob
+		if item.MimeType == "" {
+			item.Kind = detectKind(item.Content)
+		}
+		item.DeletedAt = nil
+		if item.Pinned && cm.pinLimitReached() {
+			item.Pinned = false
+		}
+		item.fillTimestamps(now)
+		cm.prepend(item)
+		cm.indexContent(item)
+		cm.notifyChange(ChangeEvent{Type: EventAdded, Item: &item})
+		added++
+	}
+	return added, skipped, invalid
+}
+
+// hasDuplicate 判断添加 content 时是否会命中已有条目，规则与 AddItemFrom 的去重一致，调用方须持有锁
+func (cm *ClipboardManager) hasDuplicate(content, mime string) bool {
+	i, ok := cm.findContent(content, mime, false)
+	if ok && cm.items[i].Pinned && *separatePinned {
+		_, ok = cm.findContent(content, mime, true)
+	}
+	return ok
+}
+
+// webhookClient 发送 webhook 通知使用的客户端，超时避免协程堆积
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// sendWebhook 将新增的条目 POST 到 url，失败只记录日志，不影响主流程
+func sendWebhook(url string, item ClipboardItem) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":      item.ID,
+		"content": item.Content,
+		"pinned":  item.Pinned,
+	})
+	if err != nil {
+		log.Printf("webhook 编码失败: %v", err)
+		return
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("webhook 发送失败: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		log.Printf("webhook 返回异常状态: %s", resp.Status)
+	}
 }
 
 func (cm *ClipboardManager) GetItems() []ClipboardItem {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	pinnedItems := []ClipboardItem{}
-	normalItems := []ClipboardItem{}
+	return cm.displayItems()
+}
+
+// GetPinned 只返回置顶条目，顺序与 GetItems 中的置顶部分一致
+func (cm *ClipboardManager) GetPinned() []ClipboardItem {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 
+	pinnedItems := []ClipboardItem{}
 	for _, item := range cm.items {
 		if item.Pinned {
 			pinnedItems = append(pinnedItems, item)
-		} else {
-			normalItems = append(normalItems, item)
 		}
 	}
+	return pinnedItems
+}
 
-	return append(pinnedItems, normalItems...)
+// GetItemsWithRevision 在同一把锁内返回列表、当前版本号和最近一次变更的时间
+func (cm *ClipboardManager) GetItemsWithRevision() ([]ClipboardItem, int64, time.Time) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	return cm.displayItems(), cm.revision, cm.modifiedAt
 }
 
-func (cm *ClipboardManager) DeleteItem(id int) bool {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+// GetRevision 返回当前列表版本号
+func (cm *ClipboardManager) GetRevision() int64 {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 
-	for i, item := range cm.items {
-		if item.ID == id {
-			cm.items = append(cm.items[:i], cm.items[i+1:]...)
-			return true
-		}
+	return cm.revision
+}
+
+// GetItem 按 ID 返回单个条目
+func (cm *ClipboardManager) GetItem(id int) (ClipboardItem, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	if i, ok := cm.indexOf(id); ok {
+		return cm.items[i], true
 	}
-	return false
+	return ClipboardItem{}, false
 }
 
-func (cm *ClipboardManager) TogglePin(id int) bool {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+// SearchItems 返回内容包含 query 的条目（不区分大小写），顺序与 GetItems 一致
+func (cm *ClipboardManager) SearchItems(query string) []ClipboardItem {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 
-	for i, item := range cm.items {
-		if item.ID == id {
-			cm.items[i].Pinned = !cm.items[i].Pinned
-			return true
+	query = strings.ToLower(query)
+	results := []ClipboardItem{}
+	for _, item := range cm.displayItems() {
+		if strings.Contains(strings.ToLower(item.Content), query) {
+			results = append(results, item)
 		}
 	}
-	return false
+	return results
 }
 
-// getDataFilePath 返回与可执行文件同目录下的数据文件路径
-func getDataFilePath() string {
-	exe, err := os.Executable()
-	if err != nil {
-		// 回退到当前工作目录
-		return "clipboard_data.txt"
-	}
-	return filepath.Join(filepath.Dir(exe), "clipboard_data.txt")
+// StatsResult 列表的统计信息
+type StatsResult struct {
+	TotalItems    int        `json:"total_items"`
+	PinnedItems   int        `json:"pinned_items"`
+	ArchivedItems int        `json:"archived_items"`
+	TotalBytes    int        `json:"total_bytes"`
+	AverageLength float64    `json:"average_length"`   // 平均内容字节数
+	Oldest        *time.Time `json:"oldest,omitempty"` // 最早创建的条目的创建时间
+	Newest        *time.Time `json:"newest,omitempty"`
 }
 
-// SaveToFile 将所有条目以 base64 编码写入文本文件
-// 格式: 每行一条记录, "id|pinned|base64(content)"
-func (cm *ClipboardManager) SaveToFile() error {
+// Stats 单次遍历统计条目数量和内容大小
+func (cm *ClipboardManager) Stats() StatsResult {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	var lines []string
+	var stats StatsResult
 	for _, item := range cm.items {
-		encoded := base64.StdEncoding.EncodeToString([]byte(item.Content))
-		line := fmt.Sprintf("%d|%t|%s", item.ID, item.Pinned, encoded)
-		lines = append(lines, line)
+		stats.TotalItems++
+		if item.Pinned {
+			stats.PinnedItems++
+		}
+		if item.Archived {
+			stats.ArchivedItems++
+		}
+		stats.TotalBytes += len(item.Content)
+		created := item.CreatedAt
+		if stats.Oldest == nil || created.Before(*stats.Oldest) {
+			stats.Oldest = &created
+		}
+		if stats.Newest == nil || created.After(*stats.Newest) {
+			stats.Newest = &created
+		}
 	}
-
-	data := strings.Join(lines, "\n")
-	return os.WriteFile(getDataFilePath(), []byte(data), 0644)
+	if stats.TotalItems > 0 {
+		stats.AverageLength = float64(stats.TotalBytes) / float64(stats.TotalItems)
+	}
+	return stats
 }
 
-// LoadFromFile 从文本文件读取 base64 编码的条目并恢复列表
-func (cm *ClipboardManager) LoadFromFile() error {
-	data, err := os.ReadFile(getDataFilePath())
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // 文件不存在，跳过
+// displayItems 返回置顶项在前的列表副本，不包含已归档的条目，调用方须持有锁
+func (cm *ClipboardManager) displayItems() []ClipboardItem {
+	items := []ClipboardItem{}
+	for _, item := range cm.orderedItems() {
+		if !item.Archived {
+			items = append(items, item)
 		}
-		return err
 	}
+	return items
+}
 
-	content := strings.TrimSpace(string(data))
-	if content == "" {
-		return nil
+// orderedItems 返回置顶项在前的全部条目副本（包含已归档的条目），同组内保持原有顺序，调用方须持有锁
+func (cm *ClipboardManager) orderedItems() []ClipboardItem {
+	pinnedItems := []ClipboardItem{}
+	normalItems := []ClipboardItem{}
+
+	for _, item := range cm.items {
+		if item.Pinned {
+			pinnedItems = append(pinnedItems, item)
+		} else {
+			normalItems = append(normalItems, item)
+		}
 	}
 
+	return append(pinnedItems, normalItems...)
+}
+
+// DeleteItem 删除条目并移入回收站，可通过 RestoreItem 恢复
+func (cm *ClipboardManager) DeleteItem(id int) bool {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	maxID := 0
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+	i, ok := cm.indexOf(id)
+	if !ok {
+		return false
+	}
+	item := cm.removeAt(i)
+	now := time.Now()
+	item.DeletedAt = &now
+	cm.trash = append([]ClipboardItem{item}, cm.trash...)
+	if len(cm.trash) > maxTrashSize {
+		cm.trash = cm.trash[:maxTrashSize]
+	}
+	cm.notifyChange(ChangeEvent{Type: EventDeleted, ID: id})
+	return true
+}
 
-		parts := strings.SplitN(line, "|", 3)
-		if len(parts) != 3 {
-			log.Printf("跳过格式错误的行: %s", line)
-			continue
-		}
+// GetTrash 返回回收站中的条目，最近删除的在前
+func (cm *ClipboardManager) GetTrash() []ClipboardItem {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 
-		id, err := strconv.Atoi(parts[0])
-		if err != nil {
-			log.Printf("跳过 ID 解析失败的行: %s", line)
-			continue
-		}
+	return append([]ClipboardItem{}, cm.trash...)
+}
 
-		pinned, err := strconv.ParseBool(parts[1])
-		if err != nil {
-			log.Printf("跳过 pinned 解析失败的行: %s", line)
+// RestoreItem 将回收站中的条目恢复到列表最前面，保留原有的 ID 和置顶、归档状态
+// 置顶数量已达上限时以非置顶状态恢复
+// 条目不在回收站中时返回 false
+func (cm *ClipboardManager) RestoreItem(id int) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for i, item := range cm.trash {
+		if item.ID != id {
 			continue
 		}
+		cm.trash = append(cm.trash[:i], cm.trash[i+1:]...)
+		item.DeletedAt = nil
+		if item.Pinned && cm.pinLimitReached() {
+			item.Pinned = false
+		}
+		cm.prepend(item)
+		cm.indexContent(item)
+		if item.Archived {
+			cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &item})
+		} else {
+			cm.notifyChange(ChangeEvent{Type: EventAdded, Item: &item})
+		}
+		return true
+	}
+	return false
+}
+
+// PurgeTrash 清空回收站，返回被永久删除的条目数
+func (cm *ClipboardManager) PurgeTrash() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	purged := len(cm.trash)
+	cm.trash = nil
+	if purged > 0 {
+		cm.dirty.Store(true) // 回收站不在列表中，不触发变更事件
+	}
+	return purged
+}
+
+// errPinLimitReached 置顶数量已达到 -max-pinned 的上限
+var errPinLimitReached = errors.New("置顶数量已达上限")
+
+// pinLimitReached 判断是否已不能再置顶新的条目，调用方须持有锁
+func (cm *ClipboardManager) pinLimitReached() bool {
+	if *maxPinned <= 0 {
+		return false
+	}
+	pinned := 0
+	for _, item := range cm.items {
+		if item.Pinned {
+			pinned++
+		}
+	}
+	return pinned >= *maxPinned
+}
+
+// TogglePin 切换条目的置顶状态，条目不存在时返回 false
+// 置顶数量已达上限时不做修改并返回 errPinLimitReached，取消置顶总是成功
+func (cm *ClipboardManager) TogglePin(id int) (bool, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	i, ok := cm.indexOf(id)
+	if !ok {
+		return false, nil
+	}
+	if !cm.items[i].Pinned && cm.pinLimitReached() {
+		return false, errPinLimitReached
+	}
+	cm.items[i].Pinned = !cm.items[i].Pinned
+	if cm.items[i].Pinned {
+		cm.items[i].Archived = false
+	}
+	updated := cm.items[i]
+	cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &updated})
+	return true, nil
+}
+
+// DeleteByContent 删除所有内容与 content 完全相同的文本条目（包括置顶和已归档的条目），返回删除数量
+// 与 DeleteItem 不同，删除的条目不进入回收站，便于彻底清理误粘贴的敏感内容
+func (cm *ClipboardManager) DeleteByContent(content string) int {
+	content = normalizeText(content)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	return cm.removeWhere(func(item ClipboardItem) bool {
+		return item.MimeType == "" && item.Content == content
+	})
+}
+
+// removeWhere 删除所有满足 match 的条目并同步索引，返回删除数量，调用方须持有写锁
+func (cm *ClipboardManager) removeWhere(match func(item ClipboardItem) bool) int {
+	kept := cm.items[:0]
+	removed := 0
+	for _, item := range cm.items {
+		if match(item) {
+			removed++
+			cm.unindexContent(item)
+			cm.notifyChange(ChangeEvent{Type: EventDeleted, ID: item.ID})
+			continue
+		}
+		kept = append(kept, item)
+	}
+	cm.items = kept
+	if removed > 0 {
+		cm.rebuildIDIndex()
+	}
+	return removed
+}
+
+// ToggleArchive 切换条目的归档状态
+// 归档会同时取消置顶，取消归档后条目回到主列表中原来的位置
+func (cm *ClipboardManager) ToggleArchive(id int) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	i, ok := cm.indexOf(id)
+	if !ok {
+		return false
+	}
+	item := cm.items[i]
+	cm.items[i].Archived = !item.Archived
+	if cm.items[i].Archived {
+		cm.items[i].Pinned = false
+	}
+	updated := cm.items[i]
+	cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &updated})
+	return true
+}
+
+// GetArchived 返回所有已归档的条目
+func (cm *ClipboardManager) GetArchived() []ClipboardItem {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	archivedItems := []ClipboardItem{}
+	for _, item := range cm.items {
+		if item.Archived {
+			archivedItems = append(archivedItems, item)
+		}
+	}
+	return archivedItems
+}
+
+// UpdateItem 修改文本条目的内容，保留置顶状态和位置
+// 开启 -keep-history 时旧内容会追加到历史版本中，超出上限时丢弃最旧的版本
+// 新内容与 AddItemFrom 一样经过 normalizeText 处理，处理后为空时返回 errContentEmpty
+func (cm *ClipboardManager) UpdateItem(id int, content string) (bool, error) {
+	content = normalizeText(content)
+	if content == "" {
+		return false, errContentEmpty
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	i, ok := cm.indexOf(id)
+	if !ok {
+		return false, nil
+	}
+	item := cm.items[i]
+	if item.MimeType != "" {
+		return false, nil
+	}
+	if item.Content == content {
+		return true, nil
+	}
+	if *keepHistory {
+		history := append(item.History, item.Content)
+		if len(history) > maxHistoryLength {
+			history = history[len(history)-maxHistoryLength:]
+		}
+		cm.items[i].History = history
+	}
+	cm.unindexContent(item)
+	cm.items[i].Content = content
+	cm.items[i].Kind = detectKind(content)
+	updated := cm.items[i]
+	cm.indexContent(updated)
+	cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &updated})
+	return true, nil
+}
+
+// errAppendTooLarge 追加后的内容超过 -max-body，这样的条目保存后可能无法重新读回
+var errAppendTooLarge = errors.New("追加后内容过大")
+
+// AppendToItem 将 content 以 sep 分隔追加到文本条目末尾，保留置顶状态和位置
+// 条目不存在或为二进制条目时返回 false；追加后超过 -max-body 时返回 errAppendTooLarge
+// 追加的部分（连同分隔符）经过 normalizeText 处理，content 处理后为空时返回 errContentEmpty
+func (cm *ClipboardManager) AppendToItem(id int, content string, sep string) (bool, error) {
+	tail := normalizeText(sep + content)
+	content = normalizeText(content)
+	if content == "" {
+		return false, errContentEmpty
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	i, ok := cm.indexOf(id)
+	if !ok {
+		return false, nil
+	}
+	item := cm.items[i]
+	if item.MimeType != "" {
+		return false, nil
+	}
+	newContent := item.Content + tail
+	if item.Content == "" {
+		newContent = content
+	}
+	if int64(len(newContent)) > *maxBodySize {
+		return false, errAppendTooLarge
+	}
+	cm.unindexContent(item)
+	cm.items[i].Content = newContent
+	cm.items[i].Kind = detectKind(newContent)
+	updated := cm.items[i]
+	cm.indexContent(updated)
+	cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &updated})
+	return true, nil
+}
+
+// SetPin 将条目设置为指定的置顶状态，重复调用结果不变
+// 状态未发生变化时不触发变更事件，置顶数量已达上限时与 TogglePin 一样返回 errPinLimitReached
+func (cm *ClipboardManager) SetPin(id int, pinned bool) (bool, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	i, ok := cm.indexOf(id)
+	if !ok {
+		return false, nil
+	}
+	item := cm.items[i]
+	if pinned && !item.Pinned && cm.pinLimitReached() {
+		return false, errPinLimitReached
+	}
+	if item.Pinned != pinned {
+		cm.items[i].Pinned = pinned
+		if pinned {
+			cm.items[i].Archived = false
+		}
+		updated := cm.items[i]
+		cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &updated})
+	}
+	return true, nil
+}
+
+// MovePinned 将置顶条目移动到置顶列表中的第 newIndex 位（从 0 开始），超出范围时移到末尾
+// 置顶顺序即内部列表中置顶项的相对顺序，保存时按显示顺序写入，因此重启后保持不变
+// 条目不存在或未置顶时返回 false
+func (cm *ClipboardManager) MovePinned(id, newIndex int) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	i, ok := cm.indexOf(id)
+	if !ok || !cm.items[i].Pinned {
+		return false
+	}
+	item := cm.items[i]
+	cm.items = append(cm.items[:i], cm.items[i+1:]...)
+
+	// 插入到原第 newIndex 个置顶项之前，没有那么多置顶项时插入到最后一个置顶项之后
+	target := 0
+	seen := 0
+	for j, other := range cm.items {
+		if !other.Pinned {
+			continue
+		}
+		if seen == newIndex {
+			target = j
+			break
+		}
+		seen++
+		target = j + 1
+	}
+	cm.items = append(cm.items[:target], append([]ClipboardItem{item}, cm.items[target:]...)...)
+	cm.rebuildIDIndex()
+	cm.notifyChange(ChangeEvent{Type: EventSnapshot, Items: cm.displayItems()})
+	return true
+}
+
+// SetExpiry 设置条目的过期时间，expiresAt 为 nil 表示永不过期
+func (cm *ClipboardManager) SetExpiry(id int, expiresAt *time.Time) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	i, ok := cm.indexOf(id)
+	if !ok {
+		return false
+	}
+	cm.items[i].ExpiresAt = expiresAt
+	updated := cm.items[i]
+	cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &updated})
+	return true
+}
+
+// RemoveExpired 删除在 now 之前已过期的非置顶条目，返回删除数量
+func (cm *ClipboardManager) RemoveExpired(now time.Time) int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	return cm.removeWhere(func(item ClipboardItem) bool {
+		return !item.Pinned && item.ExpiresAt != nil && !item.ExpiresAt.After(now)
+	})
+}
+
+// RemoveOlderThan 删除在 cutoff 之前创建的非置顶条目，返回删除数量
+func (cm *ClipboardManager) RemoveOlderThan(cutoff time.Time) int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	return cm.removeWhere(func(item ClipboardItem) bool {
+		return !item.Pinned && item.CreatedAt.Before(cutoff)
+	})
+}
+
+// Compact 清理内容完全相同的重复条目，只保留最新的一条，返回删除数量
+// 被删除的重复项按 mergeDuplicate 合并到保留的条目中，包括置顶状态和粘贴次数
+func (cm *ClipboardManager) Compact() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	seen := make(map[string]int)
+	kept := make([]ClipboardItem, 0, len(cm.items))
+	removed := 0
+	for _, item := range cm.items {
+		key := contentKey(item.Content, item.MimeType)
+		if idx, ok := seen[key]; ok {
+			kept[idx].mergeDuplicate(item)
+			updated := kept[idx]
+			cm.notifyChange(ChangeEvent{Type: EventUpdated, Item: &updated})
+			removed++
+			cm.notifyChange(ChangeEvent{Type: EventDeleted, ID: item.ID})
+			continue
+		}
+		seen[key] = len(kept)
+		kept = append(kept, item)
+	}
+	cm.items = kept
+	cm.rebuildContentIndex()
+	cm.rebuildIDIndex()
+	return removed
+}
+
+// FindDuplicates 按与 Compact 相同的规则找出内容重复的条目，不修改任何数据
+// 每组按最近使用排序，第一条即 Compact 会保留的条目；没有重复时返回空切片
+func (cm *ClipboardManager) FindDuplicates() [][]ClipboardItem {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	groupIndex := make(map[string]int)
+	var groups [][]ClipboardItem
+	for _, item := range cm.items {
+		key := contentKey(item.Content, item.MimeType)
+		if idx, ok := groupIndex[key]; ok {
+			groups[idx] = append(groups[idx], item)
+			continue
+		}
+		groupIndex[key] = len(groups)
+		groups = append(groups, []ClipboardItem{item})
+	}
+
+	duplicates := make([][]ClipboardItem, 0)
+	for _, group := range groups {
+		if len(group) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates
+}
+
+// PopItem 在同一把锁内取出并删除指定条目，适用于一次性使用的内容
+// 默认置顶项不会被取出，可通过 -pop-pinned 开启
+func (cm *ClipboardManager) PopItem(id int) (ClipboardItem, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	i, ok := cm.indexOf(id)
+	if !ok {
+		return ClipboardItem{}, false
+	}
+	item := cm.items[i]
+	if item.Pinned && !*popPinned {
+		return ClipboardItem{}, false
+	}
+	cm.removeAt(i)
+	cm.notifyChange(ChangeEvent{Type: EventDeleted, ID: id})
+	return item, true
+}
+
+// dataDir 数据文件所在目录，启动时由 resolveDataDir 确定
+var dataDir string
+
+// getDataFilePath 返回数据目录下的数据文件路径
+func getDataFilePath(name string) string {
+	return filepath.Join(dataDir, name)
+}
+
+// defaultListenAddr 未通过参数或环境变量指定时的监听地址
+const defaultListenAddr = ":8084"
+
+// resolveListenAddr 按 -addr 参数、EASYCOPY_ADDR、PORT 环境变量、默认值的优先级确定监听地址，同时返回地址的来源
+// PORT 只包含端口号，按容器平台的惯例监听所有网卡
+func resolveListenAddr(flagValue string, getenv func(string) string) (string, string) {
+	if flagValue != "" {
+		return flagValue, "-addr 参数"
+	}
+	if addr := getenv("EASYCOPY_ADDR"); addr != "" {
+		return addr, "环境变量 EASYCOPY_ADDR"
+	}
+	if port := getenv("PORT"); port != "" {
+		return ":" + port, "环境变量 PORT"
+	}
+	return defaultListenAddr, "默认值"
+}
+
+// displayHost 返回日志和自动打开浏览器使用的地址，监听所有网卡时显示为 localhost
+func displayHost(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// resolveDataDir 优先使用可执行文件所在目录，不可写时回退到用户配置目录，最后回退到当前工作目录
+func resolveDataDir() string {
+	if exe, err := os.Executable(); err == nil {
+		dir := filepath.Dir(exe)
+		err := probeWritable(dir)
+		if err == nil {
+			return dir
+		}
+		log.Printf("警告: 可执行文件目录 %s 不可写 (%v)，数据将保存到其他目录", dir, err)
+	}
+
+	if configDir, err := os.UserConfigDir(); err == nil {
+		dir := filepath.Join(configDir, "easyCopy")
+		if err := os.MkdirAll(dir, 0755); err == nil {
+			if err := probeWritable(dir); err == nil {
+				return dir
+			}
+		}
+		log.Printf("警告: 配置目录 %s 不可写", dir)
+	}
+
+	log.Printf("警告: 回退到当前工作目录保存数据")
+	return "."
+}
+
+// probeWritable 通过创建并删除临时文件检查目录是否可写
+func probeWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".easycopy-probe-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// nextIDPrefix 数据文件中的元数据前缀，用于持久化下一个可用 ID
+const nextIDPrefix = "#next-id="
+
+// versionPrefix 数据文件首行的格式版本号前缀，没有该行的旧文件视为版本 0
+const versionPrefix = "#version="
+
+// dataFormatVersion 当前数据文件格式版本
+// 版本 0: 无版本行，每行 "id|pinned|base64(content)"，后续字段可能缺失
+// 版本 1: 首行为版本号，每条记录都包含完整的 7 个字段
+// 版本 2: 追加 archived 字段，共 8 个字段
+// 版本 3: 追加 base64 编码的 source 字段，共 9 个字段
+// 版本 4: 追加 deleted 字段，有值的记录属于回收站，共 10 个字段
+// 版本 5: 追加 created 字段，共 11 个字段
+// 版本 6: 追加 last_seen 和 copy_count 字段，共 13 个字段
+const dataFormatVersion = 6
+
+// recordFieldCount 当前版本中每条记录的字段数
+const recordFieldCount = 13
+
+// errNewerDataVersion 数据文件版本高于程序支持的版本，继续运行会在保存时覆盖数据
+var errNewerDataVersion = errors.New("数据文件版本高于程序支持的版本")
+
+// migrations[v] 将版本 v 的一条记录升级到版本 v+1，格式变化时在末尾追加新的迁移函数
+// 迁移按记录逐行进行，加载时无需把整个文件读入内存
+var migrations = []func(record string) string{
+	migrateV0ToV1,
+	migrateV1ToV2,
+	migrateV2ToV3,
+	migrateV3ToV4,
+	migrateV4ToV5,
+	migrateV5ToV6,
+}
+
+// migrateRecord 将 oldVersion 版本的记录逐级升级到当前版本，调用方须保证 oldVersion 不高于当前版本
+func migrateRecord(oldVersion int, record string) string {
+	for v := oldVersion; v < dataFormatVersion; v++ {
+		record = migrations[v](record)
+	}
+	return record
+}
+
+// parseVersionLine 解析首行的版本号，line 不是版本行时视为版本 0
+func parseVersionLine(line string) (version int, isVersionLine bool, err error) {
+	if !strings.HasPrefix(line, versionPrefix) {
+		return 0, false, nil
+	}
+	version, err = strconv.Atoi(strings.TrimPrefix(line, versionPrefix))
+	if err != nil {
+		return 0, true, err
+	}
+	if version > dataFormatVersion {
+		return 0, true, fmt.Errorf("%w: %d > %d", errNewerDataVersion, version, dataFormatVersion)
+	}
+	return version, true, nil
+}
+
+// migrateV0ToV1 将字段不全的记录补齐为 7 个字段
+func migrateV0ToV1(record string) string {
+	return padRecord(record, 7)
+}
+
+// migrateV1ToV2 为记录追加空的 archived 字段（即未归档）
+func migrateV1ToV2(record string) string {
+	return padRecord(record, 8)
+}
+
+// migrateV2ToV3 为记录追加空的 source 字段（即来源未知）
+func migrateV2ToV3(record string) string {
+	return padRecord(record, 9)
+}
+
+// migrateV3ToV4 为记录追加空的 deleted 字段（即不在回收站中）
+func migrateV3ToV4(record string) string {
+	return padRecord(record, 10)
+}
+
+// migrateV4ToV5 为记录追加空的 created 字段，加载时以加载时间作为创建时间
+func migrateV4ToV5(record string) string {
+	return padRecord(record, 11)
+}
+
+// migrateV5ToV6 为记录追加空的 last_seen 和 copy_count 字段，加载时分别以创建时间和 1 补全
+func migrateV5ToV6(record string) string {
+	return padRecord(record, 13)
+}
+
+// padRecord 用空字段把记录补齐到 fieldCount 个字段
+// 字段数不足 3 的记录保持原样，由加载时的损坏检测处理
+func padRecord(record string, fieldCount int) string {
+	if n := strings.Count(record, "|") + 1; n >= 3 && n < fieldCount {
+		record += strings.Repeat("|", fieldCount-n)
+	}
+	return record
+}
+
+// SaveToFile 将所有条目以 base64 编码写入文本文件，开启 -no-persist 时不做任何操作
+// 格式: 首行 "#version=N"，第二行 "#next-id=N"，之后每行一条记录, "id|pinned|base64(content)|kind|expires|mime|history|archived|source|deleted|created|last_seen|copy_count"
+// expires 为过期时间的 Unix 秒数，永不过期时为空；mime 仅二进制条目有值
+// history 为逗号分隔的 base64 编码历史内容；deleted 为移入回收站的 Unix 秒数，正常条目为空
+// created 和 last_seen 为 Unix 秒数，copy_count 为粘贴次数
+// 即使所有条目都被删除也会写入 next-id，保证重启后 ID 不会被重复使用
+// 写入先于快照获取 saveMu，保证后开始的保存总是写入更新的状态
+// 上次保存后没有修改，或生成的内容与上次写入的完全相同时跳过写入
+func (cm *ClipboardManager) SaveToFile() error {
+	if *noPersist {
+		return nil
+	}
+
+	cm.saveMu.Lock()
+	defer cm.saveMu.Unlock()
+
+	// 先清除标记再生成快照，期间发生的修改会重新设置标记，由下一次保存写入
+	if !cm.dirty.Swap(false) && cm.hasSaved {
+		return nil
+	}
+
+	data, err := cm.serialize()
+	if err != nil {
+		cm.dirty.Store(true)
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if cm.hasSaved && sum == cm.savedHash {
+		return nil
+	}
+	if err := writeFileAtomic(cm.dataFile, data, 0644); err != nil {
+		cm.dirty.Store(true)
+		return err
+	}
+	cm.savedHash = sum
+	cm.hasSaved = true
+	return nil
+}
+
+// serialize 在读锁内生成数据文件内容
+func (cm *ClipboardManager) serialize() ([]byte, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	lines := []string{
+		versionPrefix + strconv.Itoa(dataFormatVersion),
+		nextIDPrefix + strconv.Itoa(cm.nextID),
+	}
+	// 按显示顺序写入，加载后无需再调整即可还原用户看到的顺序；回收站中的条目写在最后
+	for _, item := range append(cm.orderedItems(), cm.trash...) {
+		encoded := base64.StdEncoding.EncodeToString([]byte(item.Content))
+		expires := ""
+		if item.ExpiresAt != nil {
+			expires = strconv.FormatInt(item.ExpiresAt.Unix(), 10)
+		}
+		history := make([]string, 0, len(item.History))
+		for _, h := range item.History {
+			history = append(history, base64.StdEncoding.EncodeToString([]byte(h)))
+		}
+		archived := ""
+		if item.Archived {
+			archived = "true"
+		}
+		source := ""
+		if item.Source != "" {
+			source = base64.StdEncoding.EncodeToString([]byte(item.Source))
+		}
+		deletedAt := ""
+		if item.DeletedAt != nil {
+			deletedAt = strconv.FormatInt(item.DeletedAt.Unix(), 10)
+		}
+		line := fmt.Sprintf("%d|%t|%s|%s|%s|%s|%s|%s|%s|%s|%d|%d|%d", item.ID, item.Pinned, encoded, item.Kind, expires, item.MimeType, strings.Join(history, ","), archived, source, deletedAt, item.CreatedAt.Unix(), item.LastSeen.Unix(), item.CopyCount)
+		lines = append(lines, line)
+	}
+
+	data := []byte(strings.Join(lines, "\n"))
+	if *compressData {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	return data, nil
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免写到一半时文件损坏
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // 重命名成功后删除会失败，可以忽略
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+// gzipMagic gzip 文件头，用于识别压缩过的数据文件
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// newDataReader 返回数据文件内容的读取器，以 gzip 文件头开头时自动解压
+func newDataReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+// minRecordLineSize 单行记录上限的下限，调小 -max-body 后仍能读取此前写入的较大记录
+const minRecordLineSize = 1 << 20
+
+// recordLineSlack 记录中内容和历史以外字段（来源、时间戳等）预留的字节数
+const recordLineSlack = 64 << 10
+
+// maxRecordLineSize 数据文件中单行记录的最大字节数，超出的行会被跳过，避免一次性分配过大的内存
+// 按 -max-body 大小的内容加上 maxHistoryLength 个同样大小的历史版本经 base64 编码后计算，保证服务器接受的条目都能读回
+func maxRecordLineSize() int {
+	content := base64.StdEncoding.EncodedLen(int(*maxBodySize))
+	return max(minRecordLineSize, content*(maxHistoryLength+1)+recordLineSlack)
+}
+
+// scanLinesLimited 与 bufio.ScanLines 相同，但超过 maxLen 字节的行会被整行丢弃并调用 onSkip，而不是让扫描失败
+// 扫描器的缓冲区上限须大于 maxLen
+func scanLinesLimited(maxLen int, onSkip func()) bufio.SplitFunc {
+	skipping := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if skipping {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+		if len(data) > maxLen && bytes.IndexByte(data[:maxLen+1], '\n') < 0 {
+			skipping = true
+			onSkip()
+			return len(data), nil, nil
+		}
+		return bufio.ScanLines(data, atEOF)
+	}
+}
+
+// corruptRatioThreshold 无法解析的记录占比超过该值时视为数据文件已损坏
+const corruptRatioThreshold = 0.8
+
+// corruptMinRecords 记录总数少于该值时不按比例判断，避免一两条坏记录就丢弃其余正常的条目
+const corruptMinRecords = 10
+
+// isCorruptFile 判断数据文件是否应视为已损坏：没有任何可用记录，或记录足够多且绝大部分无法解析
+func isCorruptFile(good, bad int) bool {
+	if bad == 0 {
+		return false
+	}
+	if good == 0 {
+		return true
+	}
+	total := good + bad
+	return total >= corruptMinRecords && float64(bad)/float64(total) > corruptRatioThreshold
+}
+
+// LoadFromFile 从文本文件读取 base64 编码的条目并恢复列表，开启 -no-persist 时跳过
+// 如果 isCorruptFile 判断文件已损坏，会将原文件重命名为 .bak 备份并以空列表启动，否则只跳过无法解析的行
+func (cm *ClipboardManager) LoadFromFile() error {
+	if *noPersist {
+		return nil
+	}
+
+	f, err := os.Open(cm.dataFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // 文件不存在，跳过
+		}
+		return err
+	}
+	defer f.Close()
+
+	r, err := newDataReader(f)
+	if err != nil {
+		return fmt.Errorf("解压数据文件失败: %w", err)
+	}
+
+	var items []ClipboardItem
+	maxID := 0
+	storedNextID := 0
+	badLines := 0
+	version := 0
+	firstLine := true
+
+	// 逐行读取，单条记录过大时只跳过该行
+	lineLimit := maxRecordLineSize()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), lineLimit+1)
+	scanner.Split(scanLinesLimited(lineLimit, func() {
+		badLines++
+		log.Printf("跳过超过 %d 字节的行", lineLimit)
+	}))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if firstLine {
+			firstLine = false
+			v, isVersionLine, err := parseVersionLine(line)
+			if err != nil {
+				return fmt.Errorf("解析数据文件版本失败: %w", err)
+			}
+			version = v
+			if version < dataFormatVersion {
+				log.Printf("数据文件为版本 %d，将迁移到版本 %d", version, dataFormatVersion)
+			}
+			if isVersionLine {
+				continue
+			}
+		}
+
+		if strings.HasPrefix(line, nextIDPrefix) {
+			n, err := strconv.Atoi(strings.TrimPrefix(line, nextIDPrefix))
+			if err != nil {
+				log.Printf("跳过 next-id 解析失败的行: %s", line)
+				continue
+			}
+			storedNextID = n
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue // 其他元数据
+		}
+
+		item, err := parseRecord(migrateRecord(version, line))
+		if err != nil {
+			badLines++
+			log.Printf("跳过无法解析的行（%v）: %s", err, line)
+			continue
+		}
+		items = append(items, item)
+
+		if item.ID > maxID {
+			maxID = item.ID
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取数据文件失败: %w", err)
+	}
+	if firstLine {
+		return nil // 空文件
+	}
+	// 关闭后才能在 Windows 上重命名损坏的文件
+	f.Close()
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	// 旧文件没有 next-id 时按最大 ID 推算，二者取较大值
+	// 即使文件损坏也保留推算出的 next-id，避免备份中的 ID 被重复使用
+	cm.nextID = maxID + 1
+	if storedNextID > cm.nextID {
+		cm.nextID = storedNextID
+	}
+
+	total := len(items) + badLines
+	if isCorruptFile(len(items), badLines) {
+		backup := cm.dataFile + ".bak"
+		if err := os.Rename(cm.dataFile, backup); err != nil {
+			return fmt.Errorf("备份损坏的数据文件失败: %w", err)
+		}
+		log.Printf("警告: 数据文件中 %d/%d 条记录无法解析，已备份到 %s 并以空列表启动", badLines, total, backup)
+		return nil
+	}
+
+	now := time.Now()
+	for _, item := range items {
+		// 旧版本数据没有创建时间等字段，视为在本次加载时创建
+		item.fillTimestamps(now)
+		if item.DeletedAt != nil {
+			cm.trash = append(cm.trash, item)
+		} else {
+			cm.items = append(cm.items, item)
+		}
+	}
+	if len(cm.trash) > maxTrashSize {
+		cm.trash = cm.trash[:maxTrashSize]
+	}
+	cm.rebuildContentIndex()
+	cm.rebuildIDIndex()
+	log.Printf("从文件加载了 %d 条记录", len(cm.items))
+	return nil
+}
+
+// parseRecord 解析数据文件中的一行记录，失败时返回的错误描述失败原因
+func parseRecord(line string) (ClipboardItem, error) {
+	// 旧格式只有前三个字段，新增字段追加在末尾
+	parts := strings.Split(line, "|")
+	if len(parts) < 3 {
+		return ClipboardItem{}, errors.New("格式错误")
+	}
+	// 字段过多说明某个字段中混入了分隔符，整条记录不可信
+	if len(parts) > recordFieldCount {
+		return ClipboardItem{}, fmt.Errorf("字段数 %d 超过 %d", len(parts), recordFieldCount)
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return ClipboardItem{}, errors.New("ID 解析失败")
+	}
+
+	pinned, err := strconv.ParseBool(parts[1])
+	if err != nil {
+		return ClipboardItem{}, errors.New("pinned 解析失败")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ClipboardItem{}, errors.New("base64 解码失败")
+	}
+
+	kind := ""
+	if len(parts) > 3 {
+		kind = parts[3]
+	}
+	if kind == "" {
+		kind = detectKind(string(decoded))
+	}
+
+	var expiresAt *time.Time
+	if len(parts) > 4 && parts[4] != "" {
+		sec, err := strconv.ParseInt(parts[4], 10, 64)
+		if err != nil {
+			log.Printf("忽略无法解析的过期时间: %s", line)
+		} else {
+			t := time.Unix(sec, 0)
+			expiresAt = &t
+		}
+	}
+
+	mime := ""
+	if len(parts) > 5 {
+		mime = parts[5]
+	}
+
+	var history []string
+	if len(parts) > 6 && parts[6] != "" {
+		for _, h := range strings.Split(parts[6], ",") {
+			decoded, err := base64.StdEncoding.DecodeString(h)
+			if err != nil {
+				log.Printf("忽略无法解码的历史版本: %s", line)
+				continue
+			}
+			history = append(history, string(decoded))
+		}
+	}
+
+	archived := false
+	if len(parts) > 7 && parts[7] != "" {
+		archived, err = strconv.ParseBool(parts[7])
+		if err != nil {
+			return ClipboardItem{}, errors.New("archived 解析失败")
+		}
+	}
+
+	source := ""
+	if len(parts) > 8 && parts[8] != "" {
+		decoded, err := base64.StdEncoding.DecodeString(parts[8])
+		if err != nil {
+			log.Printf("忽略无法解码的来源: %s", line)
+		} else {
+			source = string(decoded)
+		}
+	}
+
+	var deletedAt *time.Time
+	if len(parts) > 9 && parts[9] != "" {
+		sec, err := strconv.ParseInt(parts[9], 10, 64)
+		if err != nil {
+			return ClipboardItem{}, errors.New("deleted 解析失败")
+		}
+		t := time.Unix(sec, 0)
+		deletedAt = &t
+	}
+
+	var createdAt time.Time
+	if len(parts) > 10 && parts[10] != "" {
+		sec, err := strconv.ParseInt(parts[10], 10, 64)
+		if err != nil {
+			return ClipboardItem{}, errors.New("created 解析失败")
+		}
+		createdAt = time.Unix(sec, 0)
+	}
+
+	var lastSeen time.Time
+	if len(parts) > 11 && parts[11] != "" {
+		sec, err := strconv.ParseInt(parts[11], 10, 64)
+		if err != nil {
+			return ClipboardItem{}, errors.New("last_seen 解析失败")
+		}
+		lastSeen = time.Unix(sec, 0)
+	}
+
+	copyCount := 0
+	if len(parts) > 12 && parts[12] != "" {
+		copyCount, err = strconv.Atoi(parts[12])
+		if err != nil {
+			return ClipboardItem{}, errors.New("copy_count 解析失败")
+		}
+	}
+
+	return ClipboardItem{
+		ID:        id,
+		Content:   string(decoded),
+		Pinned:    pinned,
+		Kind:      kind,
+		ExpiresAt: expiresAt,
+		MimeType:  mime,
+		History:   history,
+		Archived:  archived,
+		Source:    source,
+		DeletedAt: deletedAt,
+		CreatedAt: createdAt,
+		LastSeen:  lastSeen,
+		CopyCount: copyCount,
+	}, nil
+}
+
+// clipboardManager 默认列表，启动时确定数据目录后创建
+var clipboardManager *ClipboardManager
+
+// namespacePattern 限制命名空间名称，避免路径穿越和非法文件名
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// NamespaceRegistry 按名称懒加载各命名空间的管理器，每个命名空间使用独立的数据文件
+// 每个命名空间常驻内存并有自己的清理协程，因此数量受 limit 限制
+type NamespaceRegistry struct {
+	managers map[string]*ClipboardManager
+	limit    int // 最多加载的命名空间数量，0 表示不允许使用命名空间
+	mu       sync.Mutex
+}
+
+func NewNamespaceRegistry(limit int) *NamespaceRegistry {
+	return &NamespaceRegistry{
+		managers: make(map[string]*ClipboardManager),
+		limit:    limit,
+	}
+}
+
+// errNamespaceLimit 已加载的命名空间达到 -max-namespaces 上限
+var errNamespaceLimit = newAPIError(http.StatusServiceUnavailable, "namespace_limit_reached", "too many namespaces")
+
+// Get 返回命名空间对应的管理器，首次访问时从 clipboard_data_<name>.txt 加载
+// 命名空间数量已达上限且 name 尚未加载时返回 false
+func (nr *NamespaceRegistry) Get(name string) (*ClipboardManager, bool) {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+
+	if cm, ok := nr.managers[name]; ok {
+		return cm, true
+	}
+	if len(nr.managers) >= nr.limit {
+		return nil, false
+	}
+
+	cm := NewClipboardManager(getDataFilePath("clipboard_data_" + name + ".txt"))
+	if err := cm.LoadFromFile(); err != nil {
+		log.Printf("加载命名空间 %s 的数据失败: %v", name, err)
+	}
+	startExpiryCleanup(cm, time.Minute)
+	nr.managers[name] = cm
+	log.Printf("已创建命名空间: %s", name)
+	return cm, true
+}
+
+// basePath 规范化后的 -base-path，为空或以 / 开头且不以 / 结尾，如 /clipboard
+var basePath string
+
+// basePathPattern 限制路径前缀中的字符，前缀会原样注入到页面脚本中
+var basePathPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)*$`)
+
+// normalizeBasePath 补全开头的 / 并去掉末尾的 /，"/" 和空字符串都表示不使用前缀
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// withBasePath 去掉路径前缀后交给 next 处理，访问前缀本身时重定向到带 / 的地址，其他路径返回 404
+func withBasePath(prefix string, next http.Handler) http.Handler {
+	stripped := http.StripPrefix(prefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == prefix:
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, prefix+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// requestScope 记录请求所属的管理器和页面使用的 API 前缀
+type requestScope struct {
+	manager   *ClipboardManager
+	basePath  string
+	namespace string // 默认列表为空
+}
+
+type scopeKey struct{}
+
+// scopeFromRequest 返回请求所属的作用域，不在命名空间下时使用默认列表
+func scopeFromRequest(r *http.Request) requestScope {
+	if scope, ok := r.Context().Value(scopeKey{}).(requestScope); ok {
+		return scope
+	}
+	return requestScope{manager: clipboardManager, basePath: basePath}
+}
+
+// managerFromRequest 返回请求对应的剪贴板管理器
+func managerFromRequest(r *http.Request) *ClipboardManager {
+	return scopeFromRequest(r).manager
+}
+
+// Handler 将 /u/<name>/... 的请求去掉前缀后交给 next 处理，并注入对应命名空间的管理器
+func (nr *NamespaceRegistry) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/u/")
+		name, sub, hasSlash := strings.Cut(rest, "/")
+		if !namespacePattern.MatchString(name) {
+			http.NotFound(w, r)
+			return
+		}
+		if !hasSlash {
+			http.Redirect(w, r, basePath+"/u/"+name+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		cm, ok := nr.Get(name)
+		if !ok {
+			log.Printf("命名空间数量已达上限 %d，拒绝创建: %s", nr.limit, name)
+			writeAPIError(w, errNamespaceLimit)
+			return
+		}
+		scope := requestScope{
+			manager:   cm,
+			basePath:  basePath + "/u/" + name,
+			namespace: name,
+		}
+		r2 := r.Clone(context.WithValue(r.Context(), scopeKey{}, scope))
+		r2.URL.Path = "/" + sub
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// startExpiryCleanup 启动后台协程，定期清理过期条目和超过 -max-age 的条目，每轮最多保存一次
+func startExpiryCleanup(cm *ClipboardManager, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			removed := cm.RemoveExpired(now)
+			if removed > 0 {
+				log.Printf("已清理 %d 条过期记录", removed)
+			}
+			if *maxAge > 0 {
+				if aged := cm.RemoveOlderThan(now.Add(-*maxAge)); aged > 0 {
+					log.Printf("已清理 %d 条创建超过 %v 的记录", aged, *maxAge)
+					removed += aged
+				}
+			}
+			if removed > 0 {
+				if err := cm.SaveToFile(); err != nil {
+					log.Printf("保存数据失败: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// AuditEntry 审计日志中的一行，记录一次修改操作
+type AuditEntry struct {
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	Namespace     string    `json:"namespace,omitempty"`
+	ID            int       `json:"id,omitempty"`
+	Count         int       `json:"count,omitempty"` // 批量操作影响的条目数
+	Pinned        *bool     `json:"pinned,omitempty"`
+	Client        string    `json:"client"`
+	ContentSHA256 string    `json:"content_sha256,omitempty"` // 只记录哈希，不记录原文
+}
+
+// AuditLogger 以 JSON 行的形式追加写入审计日志，与数据文件相互独立
+type AuditLogger struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenAuditLogger 以追加模式打开审计日志文件
+func OpenAuditLogger(path string) (*AuditLogger, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLogger{f: f}, nil
+}
+
+// Write 追加一条记录，整行一次写入，多个请求并发时不会交错
+func (a *AuditLogger) Write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(append(line, '\n'))
+	return err
+}
+
+// auditLogger 未设置 -audit-log 时为 nil
+var auditLogger *AuditLogger
+
+// audit 补全时间、客户端和命名空间后写入审计日志，未启用时不做任何操作
+// content 非空时只记录其 SHA-256 哈希
+func audit(r *http.Request, entry AuditEntry, content string) {
+	if auditLogger == nil {
+		return
+	}
+	entry.Time = time.Now()
+	entry.Client = clientIP(r)
+	entry.Namespace = scopeFromRequest(r).namespace
+	if content != "" {
+		sum := sha256.Sum256([]byte(content))
+		entry.ContentSHA256 = hex.EncodeToString(sum[:])
+	}
+	if err := auditLogger.Write(entry); err != nil {
+		log.Printf("写入审计日志失败: %v", err)
+	}
+}
+
+// RateLimiter 简单的令牌桶限流器
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func NewRateLimiter(rate float64) *RateLimiter {
+	burst := math.Max(1, math.Ceil(rate))
+	return &RateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Allow 尝试取出一个令牌，桶内无令牌时返回 false
+func (rl *RateLimiter) Allow() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.tokens = math.Min(rl.burst, rl.tokens+now.Sub(rl.last).Seconds()*rl.rate)
+	rl.last = now
+
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
+
+// withRateLimit 为处理函数加上限流，limiter 为 nil 时不做限制
+func withRateLimit(limiter *RateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			writeAPIError(w, newAPIError(http.StatusTooManyRequests, "rate_limited", "too many requests"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// gzipMinSize 小于该字节数的响应不压缩，压缩带来的收益抵不过开销
+const gzipMinSize = 1024
+
+// withGzip 客户端支持时用 gzip 压缩响应，响应体不足 gzipMinSize 字节时原样发送
+func withGzip(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		next(gw, r)
+		if err := gw.finish(); err != nil {
+			log.Printf("压缩响应失败: %v", err)
+		}
+	}
+}
+
+// gzipResponseWriter 先缓冲响应体，超过 gzipMinSize 后才开始压缩输出
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    []byte
+	gz     *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// startGzip 写入响应头并把已缓冲的内容交给 gzip.Writer
+func (g *gzipResponseWriter) startGzip() error {
+	g.Header().Set("Content-Encoding", "gzip")
+	g.Header().Del("Content-Length")
+	g.writeHeader()
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// Flush 已开始压缩时把 gzip 中积压的数据刷新给客户端，仍在缓冲阶段时不做任何操作
+func (g *gzipResponseWriter) Flush() {
+	if g.gz == nil {
+		return
+	}
+	g.gz.Flush()
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) writeHeader() {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+}
+
+// finish 结束响应：已开始压缩时关闭 gzip.Writer，否则原样发送缓冲的内容
+func (g *gzipResponseWriter) finish() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if g.status == 0 && len(g.buf) == 0 {
+		return nil
+	}
+	g.writeHeader()
+	_, err := g.ResponseWriter.Write(g.buf)
+	return err
+}
+
+// authExemptPaths 启用认证后仍然公开的接口，只包含不涉及剪贴板内容的探测接口
+var authExemptPaths = map[string]bool{
+	"/api/ping": true,
+}
+
+// loadClientCAs 从 PEM 文件读取用于校验客户端证书的 CA，文件中可以包含多个证书
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s 中没有有效的 PEM 证书", path)
+	}
+	return pool, nil
+}
+
+// newTLSConfig 返回使用 cert 的服务端 TLS 配置，clientCAs 非空时要求客户端出示由其签发的证书
+func newTLSConfig(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAs != nil {
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
+
+// withBasicAuth 要求请求携带匹配的 HTTP Basic 认证信息，否则返回 401 让浏览器弹出登录框
+// authExemptPaths 中的路径（不含 -base-path 前缀）不需要认证
+func withBasicAuth(user, pass string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authExemptPaths[strings.TrimPrefix(r.URL.Path, basePath)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u, p, ok := r.BasicAuth()
+		// 分别比较用户名和密码，避免短路导致的耗时差异
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="easyCopy", charset="UTF-8"`)
+			writeAPIError(w, newAPIError(http.StatusUnauthorized, "unauthorized", "authentication required"))
+			return
+		}
+		// 浏览器会在跨站请求中自动带上缓存的 Basic 凭据，修改数据的请求必须来自本站页面
+		if isStateChanging(r) && isCrossSiteRequest(r) {
+			writeAPIError(w, errCrossOrigin)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isStateChanging 判断请求是否会修改数据：GET/HEAD/OPTIONS 以外的方法，以及 GET 形式的 quick-add
+func isStateChanging(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return strings.HasSuffix(r.URL.Path, "/api/quick-add")
+	}
+	return true
+}
+
+// isCrossSiteRequest 根据 Sec-Fetch-Site 和 Origin 判断请求是否由其他站点的页面发起，
+// 没有这两个请求头的非浏览器客户端（如 curl）视为同源
+func isCrossSiteRequest(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "cross-site", "same-site":
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || !strings.EqualFold(u.Host, r.Host)
+}
+
+// certIPAddresses 返回证书中包含的 IP：回环地址和本机所有网卡地址
+func certIPAddresses() []net.IP {
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Printf("获取网卡地址失败: %v", err)
+		return ips
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips
+}
+
+// certDNSNames 返回证书中包含的域名：localhost 和本机主机名
+func certDNSNames() []string {
+	names := []string{"localhost"}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("获取主机名失败: %v", err)
+		return names
+	}
+	if hostname != "" && hostname != "localhost" {
+		names = append(names, hostname)
+	}
+	return names
+}
+
+// generateSelfSignedCert 在内存中生成自签名 TLS 证书，主题使用 org 和 commonName（为空时不设置 CN）
+func generateSelfSignedCert(org, commonName string) (tls.Certificate, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	// 每次生成随机的 128 位序列号，避免多个实例的证书在浏览器中无法区分
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{org},
+			CommonName:   commonName,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IPAddresses:           certIPAddresses(),
+		DNSNames:              certDNSNames(),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  privateKey,
+	}, nil
+}
+
+func main() {
+	flag.Var(&replaceFlag, "replace", "添加前执行的正则替换，格式为 'pat=>repl'，可重复指定，按顺序执行")
+	flag.Parse()
+	transformers = buildTransformers()
+	basePath = normalizeBasePath(*basePathFlag)
+	if !basePathPattern.MatchString(basePath) {
+		log.Fatalf("-base-path 只能包含字母、数字和 ._~- 字符: %s", *basePathFlag)
+	}
+	log.Printf("剪贴板管理器版本: %s\n", VERSION)
+	// 不持久化时不需要探测数据目录，避免在磁盘上留下任何文件
+	if *noPersist {
+		log.Println("已启用 -no-persist: 数据只保存在内存中，重启后清空")
+	} else {
+		dataDir = resolveDataDir()
+		log.Printf("数据目录: %s", dataDir)
+	}
+	clipboardManager = NewClipboardManager(getDataFilePath("clipboard_data.txt"))
+	// 启动时从文件加载历史数据
+	if err := clipboardManager.LoadFromFile(); err != nil {
+		if errors.Is(err, errNewerDataVersion) {
+			log.Fatalf("加载历史数据失败，请升级程序: %v", err)
+		}
+		log.Printf("加载历史数据失败: %v", err)
+	}
+	startExpiryCleanup(clipboardManager, time.Minute)
+
+	if *auditLogPath != "" {
+		var err error
+		auditLogger, err = OpenAuditLogger(*auditLogPath)
+		if err != nil {
+			log.Fatalf("打开审计日志失败: %v", err)
+		}
+		log.Printf("审计日志: %s", *auditLogPath)
+	}
+
+	var limiter *RateLimiter
+	if *rateLimit > 0 {
+		limiter = NewRateLimiter(*rateLimit)
+		log.Printf("已启用限流: 每秒 %g 次请求", *rateLimit)
+	}
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, limiter)
+	http.Handle("/", mux)
+	http.Handle("/u/", NewNamespaceRegistry(*maxNamespaces).Handler(mux))
+
+	var handler http.Handler = http.DefaultServeMux
+	if basePath != "" {
+		handler = withBasePath(basePath, handler)
+		log.Printf("路径前缀: %s", basePath)
+	}
+	if (*authUser == "") != (*authPass == "") {
+		log.Fatalf("-user 和 -pass 必须同时设置")
+	}
+	if *authUser != "" {
+		handler = withBasicAuth(*authUser, *authPass, handler)
+		log.Printf("已启用 HTTP Basic 认证")
+		if !*useTLS {
+			log.Println("警告: 未启用 HTTPS，认证信息将以明文传输")
+		}
+	}
+
+	addr, addrSource := resolveListenAddr(*listenAddr, os.Getenv)
+	log.Printf("监听地址: %s（来自%s）", addr, addrSource)
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	if *useTLS {
+		cert, err := generateSelfSignedCert(*certOrg, *certCN)
+		if err != nil {
+			log.Fatalf("生成自签名证书失败: %v", err)
+		}
+		var clientCAs *x509.CertPool
+		if *clientCAPath != "" {
+			clientCAs, err = loadClientCAs(*clientCAPath)
+			if err != nil {
+				log.Fatalf("加载客户端 CA 证书失败: %v", err)
+			}
+			log.Printf("已启用客户端证书认证: %s", *clientCAPath)
+		}
+		server.TLSConfig = newTLSConfig(cert, clientCAs)
+	} else if *clientCAPath != "" {
+		log.Fatalf("-client-ca 需要启用 HTTPS，不能与 -tls=false 同时使用")
+	}
+
+	// 先监听端口再启动服务，确认端口可用后才打开浏览器
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("监听端口失败: %v", err)
+	}
+
+	scheme := "https"
+	if !*useTLS {
+		scheme = "http"
+	}
+	url := scheme + "://" + displayHost(ln.Addr()) + basePath + "/"
+	log.Println("服务器启动在 " + url)
+	if *openBrowserOnStart {
+		go openBrowser(url)
+	}
+	if !*useTLS {
+		log.Println("警告: 未启用 HTTPS，非本机访问时浏览器无法读写剪贴板，页面将提供手动粘贴和复制")
+		log.Fatal(server.Serve(ln))
+	}
+	log.Fatal(server.ServeTLS(ln, "", ""))
+}
+
+// openBrowser 使用系统默认浏览器打开 url，失败时只记录日志
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("打开浏览器失败: %v", err)
+		return
+	}
+	go cmd.Wait()
+}
+
+// saveOrFail 保存数据，失败时返回 500 和 JSON 错误信息，返回值表示是否保存成功
+func saveOrFail(w http.ResponseWriter, cm *ClipboardManager) bool {
+	if err := cm.SaveToFile(); err != nil {
+		log.Printf("保存数据失败: %v", err)
+		writeAPIError(w, newAPIError(http.StatusInternalServerError, "save_failed", "failed to persist data"))
+		return false
+	}
+	return true
+}
+
+// apiError API 统一的错误信息，以 {"error":{"code","message"}} 的形式返回
+// message 只包含面向调用方的描述，不暴露内部错误细节
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{Status: status, Code: code, Message: message}
+}
+
+var (
+	errBodyTooLarge = newAPIError(http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+	errInvalidID    = newAPIError(http.StatusBadRequest, "invalid_id", "id must be a positive integer")
+	errEmptyContent = newAPIError(http.StatusBadRequest, "empty_content", "content must not be empty")
+	errItemNotFound = newAPIError(http.StatusNotFound, "not_found", "item not found")
+
+	errContentTooLarge = newAPIError(http.StatusRequestEntityTooLarge, "content_too_large", "content too large")
+
+	errCrossOrigin = newAPIError(http.StatusForbidden, "cross_origin", "cross-origin request rejected")
+)
+
+// pinLimitError 置顶数量达到 -max-pinned 上限时返回的 409 错误
+func pinLimitError() *apiError {
+	return newAPIError(http.StatusConflict, "pin_limit_reached", fmt.Sprintf("at most %d items can be pinned", *maxPinned))
+}
+
+// writeAPIError 以统一的 JSON 格式写入错误响应
+func writeAPIError(w http.ResponseWriter, e *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]*apiError{"error": e})
+}
+
+// methodNotAllowed 返回 405 并通过 Allow 头告知允许的方法
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed"))
+}
+
+// validateID 校验请求中的 id，不合法时写入错误响应并返回 false
+func validateID(w http.ResponseWriter, id int) bool {
+	if id <= 0 {
+		writeAPIError(w, errInvalidID)
+		return false
+	}
+	return true
+}
+
+// parseIDParam 解析查询参数中的 id，不合法时写入错误响应
+func parseIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		writeAPIError(w, errInvalidID)
+		return 0, false
+	}
+	return id, true
+}
+
+// checkMediaType 启用认证时要求请求的 Content-Type 为 allowed 之一，跨站表单无法构造这类请求
+// 不符合时写入 415 并返回 false；未启用认证时不检查
+func checkMediaType(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	if *authUser == "" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if slices.Contains(allowed, mediaType) {
+		return true
+	}
+	writeAPIError(w, newAPIError(http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be "+strings.Join(allowed, " or ")))
+	return false
+}
+
+// decodeJSONBody 在限制请求体大小的前提下解析 JSON，失败时写入错误响应并返回 false
+// 请求体超过 -max-body 时返回 413，其他解析错误返回 400
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if !checkMediaType(w, r, "application/json") {
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, *maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeAPIError(w, errBodyTooLarge)
+			return false
+		}
+		writeAPIError(w, newAPIError(http.StatusBadRequest, "invalid_json", "request body is not valid JSON"))
+		return false
+	}
+	return true
+}
+
+// registerRoutes 注册页面和 API 路由，默认列表和各命名空间共用同一组路由
+func registerRoutes(mux *http.ServeMux, limiter *RateLimiter) {
+	mux.HandleFunc("/", withGzip(serveHTML))
+	mux.HandleFunc("/api/items", withGzip(handleItems))
+	mux.HandleFunc("/api/item", withGzip(handleItem))
+	mux.HandleFunc("/api/pinned", withGzip(handlePinned))
+	mux.HandleFunc("/api/search", withGzip(handleSearch))
+	mux.HandleFunc("/api/stats", handleStats)
+	mux.HandleFunc("/api/version", handleVersion)
+	mux.HandleFunc("/api/ping", handlePing)
+	mux.HandleFunc("/api/openapi.json", withGzip(handleOpenAPI))
+	mux.HandleFunc("/api/add", withRateLimit(limiter, handleAdd))
+	mux.HandleFunc("/api/update", withRateLimit(limiter, handleUpdate))
+	mux.HandleFunc("/api/append", withRateLimit(limiter, handleAppend))
+	mux.HandleFunc("/api/delete", withRateLimit(limiter, handleDelete))
+	mux.HandleFunc("/api/delete-by-content", withRateLimit(limiter, handleDeleteByContent))
+	mux.HandleFunc("/api/trash", withGzip(handleTrash))
+	mux.HandleFunc("/api/restore", withRateLimit(limiter, handleRestore))
+	mux.HandleFunc("/api/trash/purge", withRateLimit(limiter, handlePurgeTrash))
+	mux.HandleFunc("/api/toggle-pin", withRateLimit(limiter, handleTogglePin))
+	mux.HandleFunc("/api/pin", withRateLimit(limiter, handleSetPin))
+	mux.HandleFunc("/api/reorder-pinned", withRateLimit(limiter, handleReorderPinned))
+	mux.HandleFunc("/api/pop", withRateLimit(limiter, handlePop))
+	mux.HandleFunc("/api/toggle-archive", withRateLimit(limiter, handleToggleArchive))
+	mux.HandleFunc("/api/quick-add", withRateLimit(limiter, handleQuickAdd))
+	mux.HandleFunc("/api/compact", withRateLimit(limiter, handleCompact))
+	mux.HandleFunc("/api/duplicates", withGzip(handleDuplicates))
+	mux.HandleFunc("/api/export", withGzip(handleExport))
+	mux.HandleFunc("/api/import", withRateLimit(limiter, handleImport))
+	mux.HandleFunc("/api/qr", handleQR)
+	mux.HandleFunc("/api/transform", handleTransform)
+	mux.HandleFunc("/ws", newWebSocketHandler(limiter))
+}
+
+// htmlTemplate 页面模板，启动时解析一次
+var htmlTemplate = template.Must(template.New("index").Parse(htmlContent))
+
+// pageData 注入到页面模板中的配置
+type pageData struct {
+	RefreshInterval int64 // 毫秒
+	TruncateLength  int
+	APIBase         string // API 路径前缀，包含 -base-path，命名空间下再加上 /u/<name>
+	QRMaxLength     int
+	KeepHistory     bool
+	SecureContext   bool // 页面是否通过 HTTPS 提供，否则渲染手动粘贴表单
+}
+
+func serveHTML(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := pageData{
+		RefreshInterval: refreshInterval.Milliseconds(),
+		TruncateLength:  *truncateLength,
+		APIBase:         scopeFromRequest(r).basePath,
+		QRMaxLength:     maxQRContentLength,
+		KeepHistory:     *keepHistory,
+		SecureContext:   *useTLS,
+	}
+	if err := htmlTemplate.Execute(w, data); err != nil {
+		log.Printf("渲染页面失败: %v", err)
+	}
+}
+
+func handleItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	if r.URL.Query().Get("archived") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cm.GetArchived())
+		return
+	}
+
+	// since 参数与当前版本号相同时返回 304，客户端可跳过重新渲染
+	if since := r.URL.Query().Get("since"); since != "" {
+		n, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			writeAPIError(w, newAPIError(http.StatusBadRequest, "invalid_since", "since must be an integer"))
+			return
+		}
+		if n == cm.GetRevision() {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// 预览长度默认使用 -truncate-length，maxlen 可按需缩短或放大，超出范围时截到 [1, maxPreviewLength]
+	previewLen := *truncateLength
+	if maxlen := r.URL.Query().Get("maxlen"); maxlen != "" {
+		n, err := strconv.Atoi(maxlen)
+		if err != nil {
+			writeAPIError(w, newAPIError(http.StatusBadRequest, "invalid_maxlen", "maxlen must be an integer"))
+			return
+		}
+		previewLen = min(max(n, 1), maxPreviewLength)
+	}
+
+	preview := r.URL.Query().Get("preview") == "true"
+	items, revision, modifiedAt := cm.GetItemsWithRevision()
+	etag := itemsETag(revision, preview, previewLen)
+	w.Header().Set("X-Revision", strconv.FormatInt(revision, 10))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modifiedAt.UTC().Format(http.TimeFormat))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if preview {
+		json.NewEncoder(w).Encode(previewItems(items, previewLen))
+		return
+	}
+	json.NewEncoder(w).Encode(items)
+}
+
+// serverStartTime 进程启动时间，用于区分重启前后相同的版本号
+var serverStartTime = time.Now()
+
+// itemsETag 由版本号生成弱 ETag，预览模式下包含预览长度，重启后版本号从头计数，因此带上启动时间
+func itemsETag(revision int64, preview bool, previewLen int) string {
+	tag := strconv.FormatInt(serverStartTime.UnixNano(), 36) + "-" + strconv.FormatInt(revision, 10)
+	if preview {
+		tag += "-p" + strconv.Itoa(previewLen)
+	}
+	return `W/"` + tag + `"`
+}
+
+// etagMatches 按弱比较判断 If-None-Match 是否包含 etag
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// PreviewItem 预览模式下的条目，Content 可能被截断，Length 为完整内容的字节数
+type PreviewItem struct {
+	ClipboardItem
+	Truncated bool `json:"truncated"`
+	Length    int  `json:"length"`
+}
+
+// previewItems 将每个条目的内容截断到 maxLen 个字符，完整内容通过 /api/item 获取
+func previewItems(items []ClipboardItem, maxLen int) []PreviewItem {
+	previews := make([]PreviewItem, 0, len(items))
+	for _, item := range items {
+		p := PreviewItem{ClipboardItem: item, Length: len(item.Content)}
+		p.History = nil // 历史版本通过 /api/item 获取
+		p.Content, p.Truncated = truncateRunes(item.Content, maxLen)
+		previews = append(previews, p)
+	}
+	return previews
+}
+
+// truncateRunes 将 s 截断为最多 n 个字符，返回是否发生了截断
+func truncateRunes(s string, n int) (string, bool) {
+	if len(s) <= n {
+		return s, false
+	}
+	count := 0
+	for i := range s {
+		if count == n {
+			return s[:i], true
+		}
+		count++
+	}
+	return s, false
+}
+
+// handleItem 返回单个条目的完整内容
+func handleItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	id, ok := parseIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	item, ok := cm.GetItem(id)
+	if !ok {
+		writeAPIError(w, errItemNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+func handlePinned(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cm := managerFromRequest(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cm.GetPinned())
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cm := managerFromRequest(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cm.SearchItems(r.URL.Query().Get("q")))
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cm := managerFromRequest(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cm.Stats())
+}
+
+// handleVersion 返回当前运行的程序版本
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"version": VERSION})
+}
+
+// handlePing 返回服务器当前时间和运行时长，客户端可据此估算往返延迟和时钟偏差
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"time":           now.Format(time.RFC3339Nano),
+		"uptime_seconds": now.Sub(serverStartTime).Seconds(), // 使用单调时钟计算，不受系统时间调整影响
+	})
+}
+
+// handleOpenAPI 返回描述主要接口的 OpenAPI 3 文档，供工具生成客户端
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	serverURL := scopeFromRequest(r).basePath
+	if serverURL == "" {
+		serverURL = "/"
+	}
+	replacer := strings.NewReplacer("{{VERSION}}", VERSION, "{{SERVER_URL}}", serverURL)
+	io.WriteString(w, replacer.Replace(openAPIDocument))
+}
+
+func handleAdd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		Content    string `json:"content"`
+		Mime       string `json:"mime"`
+		TTLSeconds int    `json:"ttl_seconds"`
+		Source     string `json:"source"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Content == "" {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+	// 过大的值转换为 time.Duration 时会溢出，使过期时间落在过去；
+	// 0 表示不设有效期，负数视为非法而不是静默忽略
+	if req.TTLSeconds < 0 || req.TTLSeconds > maxTTLSeconds {
+		writeAPIError(w, newAPIError(http.StatusBadRequest, "invalid_ttl", fmt.Sprintf("ttl_seconds must be between 0 and %d", maxTTLSeconds)))
+		return
+	}
+
+	// 二进制内容以 base64 传入，校验类型和编码并限制解码后的大小
+	if req.Mime != "" {
+		if apiErr := validateBlob(req.Content, req.Mime); apiErr != nil {
+			writeAPIError(w, apiErr)
+			return
+		}
+	}
+
+	source := req.Source
+	if source == "" {
+		source = clientIP(r)
+	}
+	source, _ = truncateRunes(source, maxSourceLength)
+
+	item, existed, err := cm.AddItemFrom(req.Content, req.Mime, source)
+	if errors.Is(err, errContentEmpty) {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+	audit(r, AuditEntry{Action: "add", ID: item.ID}, req.Content)
+	if req.TTLSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+		cm.SetExpiry(item.ID, &expiresAt)
+		item.ExpiresAt = &expiresAt
+	}
+	if !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         item.ID,
+		"content":    item.Content,
+		"pinned":     item.Pinned,
+		"kind":       item.Kind,
+		"expires_at": item.ExpiresAt,
+		"mime":       item.MimeType,
+		"existed":    existed,
+		"copy_count": item.CopyCount,
+	})
+}
+
+// validateBlob 校验二进制条目的 MIME 类型和 base64 编码，解码后不能超过 maxBlobSize
+// /api/add 和导入共用，保证两条路径接受的数据一致
+func validateBlob(content, mime string) *apiError {
+	if !validMimeType(mime) {
+		return newAPIError(http.StatusBadRequest, "invalid_mime", "mime must be a valid media type")
+	}
+	if base64.StdEncoding.DecodedLen(len(content)) > maxBlobSize+2 {
+		return errContentTooLarge
+	}
+	decoded, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, "invalid_content", "content must be base64 when mime is set")
+	}
+	if len(decoded) > maxBlobSize {
+		return errContentTooLarge
+	}
+	return nil
+}
+
+// handleQuickAdd 便于脚本调用的添加接口，支持 GET 查询参数或表单 POST
+// 例如: curl -k "https://localhost:8084/api/quick-add?content=hello"
+func handleQuickAdd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, "GET, POST")
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, *maxBodySize)
+	if err := r.ParseForm(); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeAPIError(w, errBodyTooLarge)
+			return
+		}
+		writeAPIError(w, newAPIError(http.StatusBadRequest, "invalid_form", "request body is not a valid form"))
+		return
+	}
+
+	content := r.Form.Get("content")
+	if content == "" {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+
+	item, existed, err := cm.AddItemFrom(content, "", clientIP(r))
+	if errors.Is(err, errContentEmpty) {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+	audit(r, AuditEntry{Action: "add", ID: item.ID}, content)
+	if !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      item.ID,
+		"content": item.Content,
+		"pinned":  item.Pinned,
+		"kind":    item.Kind,
+		"existed": existed,
+	})
+}
+
+// clientIP 返回请求方的 IP，不信任 X-Forwarded-For 等可伪造的请求头
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+	if req.Content == "" {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+
+	success, err := cm.UpdateItem(req.ID, req.Content)
+	if errors.Is(err, errContentEmpty) {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+	if success {
+		audit(r, AuditEntry{Action: "update", ID: req.ID}, req.Content)
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": success})
+}
+
+// handleAppend 向已有条目追加内容，未指定 separator 时使用换行
+func handleAppend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID        int     `json:"id"`
+		Content   string  `json:"content"`
+		Separator *string `json:"separator"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+	if req.Content == "" {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+
+	sep := "\n"
+	if req.Separator != nil {
+		sep = *req.Separator
+	}
 
-		decoded, err := base64.StdEncoding.DecodeString(parts[2])
+	success, err := cm.AppendToItem(req.ID, req.Content, sep)
+	if errors.Is(err, errContentEmpty) {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+	if errors.Is(err, errAppendTooLarge) {
+		writeAPIError(w, errContentTooLarge)
+		return
+	}
+	if success {
+		audit(r, AuditEntry{Action: "append", ID: req.ID}, req.Content)
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": success})
+}
+
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID int `json:"id"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+
+	success := cm.DeleteItem(req.ID)
+	if success {
+		audit(r, AuditEntry{Action: "delete", ID: req.ID}, "")
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": success})
+}
+
+// handleDeleteByContent 按内容删除条目，适用于只知道内容不知道 ID 的脚本
+func handleDeleteByContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		Content string `json:"content"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Content == "" {
+		writeAPIError(w, errEmptyContent)
+		return
+	}
+
+	removed := cm.DeleteByContent(req.Content)
+	if removed > 0 {
+		audit(r, AuditEntry{Action: "delete-by-content", Count: removed}, req.Content)
+	}
+	if removed > 0 && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+}
+
+// handleTrash 返回回收站中的条目
+func handleTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cm := managerFromRequest(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cm.GetTrash())
+}
+
+// handleRestore 将回收站中的条目恢复到列表
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID int `json:"id"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+
+	success := cm.RestoreItem(req.ID)
+	if success {
+		audit(r, AuditEntry{Action: "restore", ID: req.ID}, "")
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": success})
+}
+
+// handlePurgeTrash 清空回收站
+func handlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+	purged := cm.PurgeTrash()
+	if purged > 0 {
+		audit(r, AuditEntry{Action: "purge-trash", Count: purged}, "")
+	}
+	if purged > 0 && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
+
+func handleTogglePin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID int `json:"id"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+
+	success, err := cm.TogglePin(req.ID)
+	if success {
+		audit(r, AuditEntry{Action: "toggle-pin", ID: req.ID}, "")
+	}
+	if errors.Is(err, errPinLimitReached) {
+		writeAPIError(w, pinLimitError())
+		return
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": success})
+}
+
+// handleSetPin 显式设置置顶状态，供需要幂等语义的脚本使用
+func handleSetPin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID     int  `json:"id"`
+		Pinned bool `json:"pinned"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+
+	success, err := cm.SetPin(req.ID, req.Pinned)
+	if success {
+		audit(r, AuditEntry{Action: "set-pin", ID: req.ID, Pinned: &req.Pinned}, "")
+	}
+	if errors.Is(err, errPinLimitReached) {
+		writeAPIError(w, pinLimitError())
+		return
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": success})
+}
+
+// handleReorderPinned 将置顶条目移动到置顶列表中的指定位置
+func handleReorderPinned(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID    int `json:"id"`
+		Index int `json:"index"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+	if req.Index < 0 {
+		writeAPIError(w, newAPIError(http.StatusBadRequest, "invalid_index", "index must not be negative"))
+		return
+	}
+
+	success := cm.MovePinned(req.ID, req.Index)
+	if success {
+		audit(r, AuditEntry{Action: "reorder-pinned", ID: req.ID}, "")
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": success})
+}
+
+func handleToggleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID int `json:"id"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+
+	success := cm.ToggleArchive(req.ID)
+	if success {
+		audit(r, AuditEntry{Action: "toggle-archive", ID: req.ID}, "")
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": success})
+}
+
+func handlePop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+
+	var req struct {
+		ID int `json:"id"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateID(w, req.ID) {
+		return
+	}
+
+	item, success := cm.PopItem(req.ID)
+	if success {
+		audit(r, AuditEntry{Action: "pop", ID: req.ID}, item.Content)
+	}
+	if success && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": success,
+		"content": item.Content,
+	})
+}
+
+func handleCompact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	cm := managerFromRequest(r)
+	removed := cm.Compact()
+	if removed > 0 {
+		audit(r, AuditEntry{Action: "compact", Count: removed}, "")
+	}
+	if removed > 0 && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+}
+
+// handleDuplicates 预览 /api/compact 会合并的重复条目，removable 为将被删除的条目数
+func handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cm := managerFromRequest(r)
+	groups := cm.FindDuplicates()
+	removable := 0
+	for _, group := range groups {
+		removable += len(group) - 1
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"groups":    groups,
+		"removable": removable,
+	})
+}
+
+// jsonlFlushInterval 流式导出时每写入多少条刷新一次，让客户端能边接收边处理
+const jsonlFlushInterval = 100
+
+var errInvalidFormat = newAPIError(http.StatusBadRequest, "invalid_format", "format must be json or jsonl")
+
+// handleExport 以 JSON 数组导出所有条目（包含已归档的条目），可直接提交给另一个实例的 /api/import
+// format=jsonl 时每行一个条目，边编码边写出，不在内存中构造完整的响应体
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cm := managerFromRequest(r)
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "jsonl":
+		exportJSONLines(w, cm)
+		return
+	default:
+		writeAPIError(w, errInvalidFormat)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="easycopy-export.json"`)
+	json.NewEncoder(w).Encode(cm.ExportItems())
+}
+
+// exportJSONLines 流式写出 JSON Lines，响应头发出后出错只能中断连接，因此只记录日志
+// 先在锁内复制列表再编码写出，慢速客户端不会长时间占用读锁而阻塞其他请求
+func exportJSONLines(w http.ResponseWriter, cm *ClipboardManager) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="easycopy-export.jsonl"`)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, item := range cm.ExportItems() {
+		// Encoder 在每个值之后写入换行，正好是一行一个条目
+		if err := enc.Encode(item); err != nil {
+			log.Printf("流式导出中断: %v", err)
+			return
+		}
+		if (i+1)%jsonlFlushInterval == 0 && flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// decodeJSONLines 解析 JSON Lines 格式的请求体，逐条解码，不需要先读入整个请求体
+// 失败时写入错误响应并返回 false，错误规则与 decodeJSONBody 相同
+func decodeJSONLines(w http.ResponseWriter, r *http.Request) ([]ClipboardItem, bool) {
+	if !checkMediaType(w, r, "application/x-ndjson", "application/json") {
+		return nil, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, *maxBodySize)
+	dec := json.NewDecoder(r.Body)
+	var items []ClipboardItem
+	for {
+		var item ClipboardItem
+		err := dec.Decode(&item)
+		if err == io.EOF {
+			return items, true
+		}
 		if err != nil {
-			log.Printf("跳过 base64 解码失败的行: %s", line)
-			continue
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				writeAPIError(w, errBodyTooLarge)
+				return nil, false
+			}
+			writeAPIError(w, newAPIError(http.StatusBadRequest, "invalid_json", fmt.Sprintf("record %d is not valid JSON", len(items)+1)))
+			return nil, false
 		}
+		items = append(items, item)
+	}
+}
 
-		cm.items = append(cm.items, ClipboardItem{
-			ID:      id,
-			Content: string(decoded),
-			Pinned:  pinned,
-		})
+// handleImport 合并 /api/export 导出的条目，内容重复的条目会被跳过
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
 
-		if id > maxID {
-			maxID = id
+	cm := managerFromRequest(r)
+
+	var items []ClipboardItem
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		if !decodeJSONBody(w, r, &items) {
+			return
+		}
+	case "jsonl":
+		var ok bool
+		if items, ok = decodeJSONLines(w, r); !ok {
+			return
 		}
+	default:
+		writeAPIError(w, errInvalidFormat)
+		return
 	}
 
-	cm.nextID = maxID + 1
-	log.Printf("从文件加载了 %d 条记录", len(cm.items))
-	return nil
+	added, skipped, invalid := cm.ImportItems(items)
+	if added > 0 {
+		audit(r, AuditEntry{Action: "import", Count: added}, "")
+	}
+	if added > 0 && !saveOrFail(w, cm) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"added": added, "skipped": skipped, "invalid": invalid})
 }
 
-var clipboardManager = NewClipboardManager()
+const (
+	// maxQRContentLength 二维码可编码的最大字节数（中等纠错级别下约 2331 字节，留出余量）
+	maxQRContentLength = 2000
+	qrImageSize        = 256
+)
 
-// generateSelfSignedCert 在内存中生成自签名 TLS 证书
-func generateSelfSignedCert() (tls.Certificate, error) {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return tls.Certificate{}, err
+// handleQR 将指定条目的内容生成二维码 PNG，方便传到手机上
+func handleQR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
 	}
 
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			Organization: []string{"Clipboard Manager"},
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
-		DNSNames:              []string{"localhost"},
-	}
+	cm := managerFromRequest(r)
 
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
-	if err != nil {
-		return tls.Certificate{}, err
+	id, ok := parseIDParam(w, r)
+	if !ok {
+		return
 	}
 
-	return tls.Certificate{
-		Certificate: [][]byte{certDER},
-		PrivateKey:  privateKey,
-	}, nil
-}
-
-func main() {
-	log.Printf("剪贴板管理器版本: %s\n", VERSION)
-	// 启动时从文件加载历史数据
-	if err := clipboardManager.LoadFromFile(); err != nil {
-		log.Printf("加载历史数据失败: %v", err)
+	item, ok := cm.GetItem(id)
+	if !ok {
+		writeAPIError(w, errItemNotFound)
+		return
+	}
+	if len(item.Content) > maxQRContentLength {
+		writeAPIError(w, newAPIError(http.StatusRequestEntityTooLarge, "content_too_large", "content too long for QR code"))
+		return
 	}
 
-	http.HandleFunc("/", serveHTML)
-	http.HandleFunc("/api/items", handleItems)
-	http.HandleFunc("/api/add", handleAdd)
-	http.HandleFunc("/api/delete", handleDelete)
-	http.HandleFunc("/api/toggle-pin", handleTogglePin)
-
-	cert, err := generateSelfSignedCert()
+	png, err := qrcode.Encode(item.Content, qrcode.Medium, qrImageSize)
 	if err != nil {
-		log.Fatalf("生成自签名证书失败: %v", err)
+		log.Printf("生成二维码失败: %v", err)
+		writeAPIError(w, newAPIError(http.StatusInternalServerError, "qr_failed", "failed to generate QR code"))
+		return
 	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	}
+// transformOps /api/transform 支持的操作，只生成副本，不修改已保存的条目
+var transformOps = map[string]func(string) string{
+	"strip-tracking": stripTrackingParams,
+	"trim":           strings.TrimSpace,
+	"lower":          strings.ToLower,
+	"upper":          strings.ToUpper,
+}
 
-	server := &http.Server{
-		Addr:      ":8084",
-		TLSConfig: tlsConfig,
-	}
+// embeddedURLPattern 匹配文本中出现的链接
+var embeddedURLPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
 
-	log.Println("服务器启动在 https://localhost:8084")
-	log.Fatal(server.ListenAndServeTLS("", ""))
+// stripTrackingParams 删除文本中所有链接的 utm_* 等跟踪参数，其余参数保持原有顺序
+func stripTrackingParams(content string) string {
+	return embeddedURLPattern.ReplaceAllStringFunc(content, stripURLTracking)
 }
 
-func serveHTML(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(htmlContent))
+// trackingParams 除 utm_ 前缀外需要删除的跟踪参数
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_gl":     true,
 }
 
-func handleItems(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(clipboardManager.GetItems())
+func stripURLTracking(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+	// 直接按 & 拆分原始查询串，避免 url.Values 重新排序和转义保留下来的参数
+	parts := strings.Split(u.RawQuery, "&")
+	kept := make([]string, 0, len(parts))
+	for _, part := range parts {
+		key, _, _ := strings.Cut(part, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, "utm_") || trackingParams[key] {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	if len(kept) == len(parts) {
+		return raw
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	u.ForceQuery = false
+	return u.String()
 }
 
-func handleAdd(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleTransform 返回对条目内容执行 op 之后的结果，条目本身保持不变
+func handleTransform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
 		return
 	}
 
-	var req struct {
-		Content string `json:"content"`
+	cm := managerFromRequest(r)
+
+	id, ok := parseIDParam(w, r)
+	if !ok {
+		return
+	}
+	op := r.URL.Query().Get("op")
+	transform, ok := transformOps[op]
+	if !ok {
+		writeAPIError(w, newAPIError(http.StatusBadRequest, "invalid_op", "op must be one of strip-tracking, trim, lower, upper"))
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	item, ok := cm.GetItem(id)
+	if !ok {
+		writeAPIError(w, errItemNotFound)
+		return
+	}
+	if item.MimeType != "" {
+		writeAPIError(w, newAPIError(http.StatusBadRequest, "unsupported_item", "binary items cannot be transformed"))
 		return
 	}
 
-	item, existed := clipboardManager.AddItem(req.Content)
-	clipboardManager.SaveToFile()
+	content := transform(item.Content)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	json.NewEncoder(w).Encode(map[string]any{
 		"id":      item.ID,
-		"content": item.Content,
-		"pinned":  item.Pinned,
-		"existed": existed,
+		"op":      op,
+		"content": content,
+		"changed": content != item.Content,
 	})
 }
 
-func handleDelete(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
 
-	var req struct {
-		ID int `json:"id"`
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsMessage 客户端通过 WebSocket 发送的操作
+type wsMessage struct {
+	Action  string `json:"action"`
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+}
+
+// newWebSocketHandler 返回 WebSocket 处理函数：连接建立后先推送当前列表快照，之后推送每一次变更
+// 同时接受客户端发来的 add/delete/toggle-pin 操作，这些操作与 HTTP 修改接口共用同一个限流器
+func newWebSocketHandler(limiter *RateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, limiter)
 	}
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+func handleWebSocket(w http.ResponseWriter, r *http.Request, limiter *RateLimiter) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket 升级失败: %v", err)
 		return
 	}
+	defer conn.Close()
 
-	success := clipboardManager.DeleteItem(req.ID)
-	if success {
-		clipboardManager.SaveToFile()
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": success})
-}
+	cm := managerFromRequest(r)
+	snapshot, events, cancel := cm.Subscribe()
+	defer cancel()
 
-func handleTogglePin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// 读协程负责处理客户端消息，连接断开时关闭 done 通知写循环退出
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(*maxBodySize)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			handleWebSocketMessage(r, cm, limiter, msg)
+		}
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := conn.WriteJSON(snapshot); err != nil {
 		return
 	}
 
-	var req struct {
-		ID int `json:"id"`
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// 订阅因积压被关闭，断开连接让客户端重连获取新快照
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
 	}
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+// handleWebSocketMessage 执行客户端发来的操作，结果通过订阅事件广播给所有连接
+func handleWebSocketMessage(r *http.Request, cm *ClipboardManager, limiter *RateLimiter, msg wsMessage) {
+	// 超出限流的操作直接丢弃，客户端收不到对应的变更推送
+	if limiter != nil && !limiter.Allow() {
+		log.Printf("WebSocket 操作被限流，已丢弃: %s", msg.Action)
 		return
 	}
-
-	success := clipboardManager.TogglePin(req.ID)
-	if success {
-		clipboardManager.SaveToFile()
+	var changed bool
+	switch msg.Action {
+	case "add":
+		if msg.Content == "" {
+			return
+		}
+		item, _, err := cm.AddItemFrom(msg.Content, "", clientIP(r))
+		if err != nil {
+			return
+		}
+		audit(r, AuditEntry{Action: "add", ID: item.ID}, msg.Content)
+		changed = true
+	case "delete":
+		changed = cm.DeleteItem(msg.ID)
+	case "toggle-pin":
+		var err error
+		changed, err = cm.TogglePin(msg.ID)
+		if err != nil {
+			log.Printf("WebSocket 置顶失败: %v", err)
+		}
+	default:
+		log.Printf("忽略未知的 WebSocket 操作: %s", msg.Action)
+	}
+	if changed && msg.Action != "add" {
+		audit(r, AuditEntry{Action: msg.Action, ID: msg.ID}, "")
+	}
+	if changed {
+		if err := cm.SaveToFile(); err != nil {
+			log.Printf("保存数据失败: %v", err)
+		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": success})
 }
 
+// openAPIDocument 手工维护的 OpenAPI 文档，修改相关接口的请求或响应时需要同步更新
+// {{VERSION}} 和 {{SERVER_URL}} 在返回时替换为当前版本号和请求所在的路径前缀
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "easyCopy",
+    "version": "{{VERSION}}"
+  },
+  "servers": [{"url": "{{SERVER_URL}}"}],
+  "paths": {
+    "/api/items": {
+      "get": {
+        "summary": "获取列表，置顶项在前，不包含已归档的条目",
+        "parameters": [
+          {"name": "preview", "in": "query", "schema": {"type": "boolean"}, "description": "为 true 时截断内容"},
+          {"name": "maxlen", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100000}, "description": "预览长度"},
+          {"name": "since", "in": "query", "schema": {"type": "integer"}, "description": "与当前版本号相同时返回 304"},
+          {"name": "archived", "in": "query", "schema": {"type": "boolean"}, "description": "为 true 时只返回已归档的条目"},
+          {"name": "If-None-Match", "in": "header", "schema": {"type": "string"}, "description": "与当前 ETag 相同时返回 304"}
+        ],
+        "responses": {
+          "200": {
+            "description": "条目列表",
+            "headers": {
+              "X-Revision": {"schema": {"type": "integer"}},
+              "ETag": {"schema": {"type": "string"}},
+              "Last-Modified": {"schema": {"type": "string"}, "description": "列表最近一次变更的时间"}
+            },
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Item"}}}}
+          },
+          "304": {"description": "列表没有变化（since 或 If-None-Match）"},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/add": {
+      "post": {
+        "summary": "添加条目，内容已存在时移到最前面",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["content"],
+                "properties": {
+                  "content": {"type": "string", "description": "mime 非空时为 base64 编码的二进制数据"},
+                  "mime": {"type": "string", "description": "合法的 MIME 类型，如 image/png，不能包含 | 和逗号"},
+                  "ttl_seconds": {"type": "integer", "minimum": 0, "maximum": 315360000, "description": "有效期（秒），到期后非置顶条目会被清理"},
+                  "source": {"type": "string", "description": "内容来源，默认为客户端 IP"}
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "添加或移动后的条目",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "id": {"type": "integer"},
+                    "content": {"type": "string"},
+                    "pinned": {"type": "boolean"},
+                    "kind": {"type": "string"},
+                    "expires_at": {"type": "string", "format": "date-time", "nullable": true},
+                    "mime": {"type": "string"},
+                    "existed": {"type": "boolean", "description": "内容是否已存在"},
+                    "copy_count": {"type": "integer", "description": "该内容被粘贴的次数，重复粘贴时加一"}
+                  }
+                }
+              }
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/delete": {
+      "post": {
+        "summary": "删除条目并移入回收站",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/IDRequest"}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Success"},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/toggle-pin": {
+      "post": {
+        "summary": "切换条目的置顶状态",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/IDRequest"}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Success"},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Item": {
+        "type": "object",
+        "required": ["id", "content", "pinned", "kind", "archived"],
+        "properties": {
+          "id": {"type": "integer"},
+          "content": {"type": "string"},
+          "pinned": {"type": "boolean"},
+          "kind": {"type": "string", "enum": ["url", "email", "hex-color", "json", "text", "blob"]},
+          "expires_at": {"type": "string", "format": "date-time"},
+          "mime": {"type": "string"},
+          "history": {"type": "array", "items": {"type": "string"}},
+          "archived": {"type": "boolean"},
+          "source": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time", "description": "首次添加的时间，重新粘贴时不变"},
+          "last_seen": {"type": "string", "format": "date-time", "description": "最近一次添加或重新粘贴的时间"},
+          "copy_count": {"type": "integer", "description": "粘贴次数"},
+          "truncated": {"type": "boolean", "description": "仅预览模式"},
+          "length": {"type": "integer", "description": "仅预览模式，完整内容的字节数"}
+        }
+      },
+      "IDRequest": {
+        "type": "object",
+        "required": ["id"],
+        "properties": {"id": {"type": "integer", "minimum": 1}}
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "object",
+            "properties": {"code": {"type": "string"}, "message": {"type": "string"}}
+          }
+        }
+      }
+    },
+    "responses": {
+      "Success": {
+        "description": "操作结果，条目不存在时 success 为 false",
+        "content": {"application/json": {"schema": {"type": "object", "properties": {"success": {"type": "boolean"}}}}}
+      },
+      "Error": {
+        "description": "错误",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    }
+  }
+}`
+
 const htmlContent = `<!DOCTYPE html>
 <html lang="zh-CN">
 <head>
@@ -391,6 +3975,18 @@ const htmlContent = `<!DOCTYPE html>
         }
         .paste-btn:hover { transform: translateY(-2px); box-shadow: 0 6px 20px rgba(102, 126, 234, 0.6); }
         .paste-btn:active { transform: translateY(0); }
+        .search-input {
+            flex: 1; min-width: 200px; padding: 12px 15px; font-size: 16px;
+            border: 2px solid #e9ecef; border-radius: 8px; outline: none;
+            transition: border-color 0.3s ease;
+        }
+        .search-input:focus { border-color: #667eea; }
+        .manual-paste { display: flex; gap: 10px; margin-top: 15px; }
+        .manual-paste textarea, .copy-fallback-text {
+            flex: 1; min-height: 60px; padding: 10px; font-size: 14px;
+            border: 2px solid #e9ecef; border-radius: 8px; resize: vertical;
+        }
+        .copy-fallback-text { width: 100%; margin-bottom: 20px; }
         .auto-refresh-control {
             display: flex; align-items: center; gap: 10px;
             background: #f8f9fa; padding: 10px 20px; border-radius: 8px;
@@ -463,6 +4059,7 @@ const htmlContent = `<!DOCTYPE html>
             background: inherit; padding-left: 5px;
         }
         .item-content.expanded { max-height: none; }
+        .item-image { max-width: 100%; max-height: 300px; border-radius: 6px; display: block; }
         .item-content.expanded::after { display: none; }
         .pin-badge {
             position: absolute; top: 5px; left: 5px;
@@ -470,6 +4067,20 @@ const htmlContent = `<!DOCTYPE html>
             padding: 2px 8px; border-radius: 4px;
             font-size: 12px; font-weight: bold;
         }
+        .kind-badge {
+            display: inline-block; margin-bottom: 6px;
+            background: #667eea; color: white;
+            padding: 1px 8px; border-radius: 4px; font-size: 12px;
+        }
+        .kind-badge.kind-email { background: #17a2b8; }
+        .kind-badge.kind-json { background: #6f42c1; }
+        .kind-badge.kind-hex-color { background: #343a40; }
+        .color-swatch {
+            display: inline-block; width: 10px; height: 10px; margin-right: 4px;
+            border-radius: 2px; border: 1px solid rgba(255,255,255,0.6);
+        }
+        .item-body { flex: 1; margin-right: 15px; min-width: 0; }
+        .item-body .item-content { margin-right: 0; }
         .button-group { display: flex; gap: 8px; }
         .action-btn {
             color: white; border: none; padding: 8px 16px;
@@ -481,6 +4092,13 @@ const htmlContent = `<!DOCTYPE html>
         .pin-btn { background: #ffc107; color: #856404; }
         .pin-btn:hover { background: #e0a800; transform: scale(1.05); }
         .pin-btn.pinned { background: #856404; color: white; }
+        .pop-btn { background: #fd7e14; }
+        .pop-btn:hover { background: #e36209; transform: scale(1.05); }
+        .qr-btn { background: #17a2b8; }
+        .qr-btn:hover { background: #138496; transform: scale(1.05); }
+        .archive-btn { background: #6f42c1; }
+        .archive-btn:hover { background: #5a32a3; transform: scale(1.05); }
+        .archived-container { margin-top: 20px; }
         .delete-btn { background: #dc3545; }
         .delete-btn:hover { background: #c82333; transform: scale(1.05); }
         .action-btn:active { transform: scale(0.95); }
@@ -516,8 +4134,18 @@ const htmlContent = `<!DOCTYPE html>
         }
         .modal-btn-confirm { background: #dc3545; color: white; }
         .modal-btn-confirm:hover { background: #c82333; }
+        .history-list { list-style: none; text-align: left; max-height: 300px; overflow-y: auto; margin-bottom: 20px; }
+        .history-entry {
+            display: flex; justify-content: space-between; align-items: center; gap: 10px;
+            padding: 8px; border-bottom: 1px solid #e9ecef;
+        }
+        .history-entry span { flex: 1; word-break: break-all; color: #333; max-height: 4.5em; overflow: hidden; }
+        .history-btn { background: #6c757d; }
+        .history-btn:hover { background: #5a6268; transform: scale(1.05); }
+        .qr-image { display: block; margin: 0 auto 20px; width: 256px; height: 256px; }
         .modal-btn-cancel { background: #6c757d; color: white; }
         .modal-btn-cancel:hover { background: #5a6268; }
+        .footer { text-align: center; color: rgba(255, 255, 255, 0.8); font-size: 12px; margin-top: 20px; }
     </style>
 </head>
 <body>
@@ -526,6 +4154,7 @@ const htmlContent = `<!DOCTYPE html>
         <div class="paste-box">
             <div class="controls-row">
                 <button class="paste-btn" onclick="pasteFromClipboard()">📌 粘贴剪贴板内容</button>
+                <input type="search" class="search-input" id="searchInput" placeholder="🔍 搜索（按 / 聚焦）" oninput="onSearchInput()">
                 <div class="auto-refresh-control">
                     <span class="refresh-label">
                         🔄 自动刷新
@@ -537,6 +4166,12 @@ const htmlContent = `<!DOCTYPE html>
                     </label>
                 </div>
             </div>
+            {{if not .SecureContext}}
+            <div class="manual-paste">
+                <textarea id="manualInput" placeholder="当前页面未使用 HTTPS，浏览器禁止读取剪贴板，请将内容粘贴到这里"></textarea>
+                <button class="paste-btn" onclick="submitManualInput()">添加</button>
+            </div>
+            {{end}}
         </div>
         <div class="columns-wrapper">
             <div class="column">
@@ -556,22 +4191,65 @@ const htmlContent = `<!DOCTYPE html>
                 </div>
             </div>
         </div>
+        <div class="list-container archived-container">
+            <h2 class="list-title">🗄️ 已归档
+                <button class="action-btn archive-btn" id="archivedToggle" onclick="toggleArchivedView()">显示</button>
+            </h2>
+            <ul id="archivedList" class="clipboard-list" style="display: none"></ul>
+        </div>
+        <div class="list-container archived-container">
+            <h2 class="list-title">🗑️ 回收站
+                <button class="action-btn archive-btn" id="trashToggle" onclick="toggleTrashView()">显示</button>
+                <button class="action-btn delete-btn" onclick="purgeTrash()">清空</button>
+            </h2>
+            <ul id="trashList" class="clipboard-list" style="display: none"></ul>
+        </div>
+        <div class="footer" id="versionFooter"></div>
     </div>
     <div id="notification" class="notification"></div>
     <div id="deleteModal" class="modal">
         <div class="modal-content">
             <h3 class="modal-title">确认删除</h3>
-            <p class="modal-text">确定要删除这条记录吗？</p>
+            <p class="modal-text">确定要删除这条记录吗？删除后可在回收站中恢复。</p>
             <div class="modal-buttons">
                 <button class="modal-btn modal-btn-confirm" onclick="confirmDelete()">确认删除</button>
                 <button class="modal-btn modal-btn-cancel" onclick="cancelDelete()">取消</button>
             </div>
         </div>
     </div>
+    <div id="qrModal" class="modal" onclick="if (event.target === this) closeQR()">
+        <div class="modal-content">
+            <h3 class="modal-title">扫码获取内容</h3>
+            <img id="qrImage" class="qr-image" alt="二维码">
+            <div class="modal-buttons">
+                <button class="modal-btn modal-btn-cancel" onclick="closeQR()">关闭</button>
+            </div>
+        </div>
+    </div>
+    <div id="copyFallbackModal" class="modal" onclick="if (event.target === this) closeCopyFallback()">
+        <div class="modal-content">
+            <h3 class="modal-title">手动复制</h3>
+            <p class="modal-text">浏览器不允许在当前页面写入剪贴板，请按 Ctrl+C 复制选中的内容</p>
+            <textarea id="copyFallbackText" class="copy-fallback-text" readonly></textarea>
+            <div class="modal-buttons">
+                <button class="modal-btn modal-btn-cancel" onclick="closeCopyFallback()">关闭</button>
+            </div>
+        </div>
+    </div>
+    <div id="historyModal" class="modal" onclick="if (event.target === this) closeHistory()">
+        <div class="modal-content">
+            <h3 class="modal-title">历史版本</h3>
+            <ul id="historyList" class="history-list"></ul>
+            <div class="modal-buttons">
+                <button class="modal-btn modal-btn-cancel" onclick="closeHistory()">关闭</button>
+            </div>
+        </div>
+    </div>
     <script>
         let deleteItemId = null;
-        const TRUNCATE_LENGTH = 1000;
-        const REFRESH_INTERVAL = 2000;
+        const TRUNCATE_LENGTH = {{.TruncateLength}};
+        const REFRESH_INTERVAL = {{.RefreshInterval}};
+        const API_BASE = '{{.APIBase}}';
         let autoRefreshEnabled = false;
         let refreshTimer = null;
         
@@ -591,7 +4269,7 @@ const htmlContent = `<!DOCTYPE html>
         async function confirmDelete() {
             if (!deleteItemId) return;
             try {
-                const r = await fetch('/api/delete', {
+                const r = await fetch(API_BASE + '/api/delete', {
                     method: 'POST',
                     headers: {'Content-Type': 'application/json'},
                     body: JSON.stringify({id: deleteItemId})
@@ -601,41 +4279,301 @@ const htmlContent = `<!DOCTYPE html>
             } catch(e) { showNotification('❌ 删除失败'); }
             cancelDelete();
         }
+        function blobToBase64(blob) {
+            return new Promise((resolve, reject) => {
+                const reader = new FileReader();
+                reader.onload = () => resolve(reader.result.split(',')[1]);
+                reader.onerror = reject;
+                reader.readAsDataURL(blob);
+            });
+        }
+        // readClipboardImage 读取剪贴板中的图片，不支持或没有图片时返回 null
+        async function readClipboardImage() {
+            if (!navigator.clipboard || !navigator.clipboard.read) return null;
+            try {
+                for (const ci of await navigator.clipboard.read()) {
+                    const type = ci.types.find(t => t.startsWith('image/'));
+                    if (type) {
+                        const blob = await ci.getType(type);
+                        return {content: await blobToBase64(blob), mime: type};
+                    }
+                }
+            } catch(e) {}
+            return null;
+        }
+        async function addContent(body) {
+            const r = await fetch(API_BASE + '/api/add', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify(body)
+            });
+            if (r.ok) {
+                const data = await r.json();
+                showNotification(data.existed ? '📌 已存在，已移至最前' : '✅ 已添加到列表');
+                loadItems();
+                return true;
+            }
+            showNotification('❌ 添加失败');
+            return false;
+        }
+        // submitManualInput 非 HTTPS 页面无法读取剪贴板时，提交手动粘贴的内容
+        async function submitManualInput() {
+            const input = document.getElementById('manualInput');
+            if (!input.value.trim()) { showNotification('⚠️ 内容为空'); return; }
+            try {
+                if (await addContent({content: input.value})) input.value = '';
+            } catch(e) { showNotification('❌ 添加失败'); }
+        }
         async function pasteFromClipboard() {
+            if (!navigator.clipboard) {
+                const input = document.getElementById('manualInput');
+                if (input) input.focus();
+                showNotification('⚠️ 浏览器不允许读取剪贴板，请手动粘贴');
+                return;
+            }
             try {
-                const t = await navigator.clipboard.readText();
-                if (!t || !t.trim()) { showNotification('⚠️ 剪贴板为空'); return; }
-                const r = await fetch('/api/add', {
-                    method: 'POST',
-                    headers: {'Content-Type': 'application/json'},
-                    body: JSON.stringify({content: t})
-                });
-                if (r.ok) {
-                    const data = await r.json();
-                    showNotification(data.existed ? '📌 已存在，已移至最前' : '✅ 已添加到列表');
-                    loadItems();
-                } else {
-                    showNotification('❌ 添加失败');
+                let body = await readClipboardImage();
+                if (!body) {
+                    const t = await navigator.clipboard.readText();
+                    if (!t || !t.trim()) { showNotification('⚠️ 剪贴板为空'); return; }
+                    body = {content: t};
                 }
+                await addContent(body);
             } catch(e) { showNotification('❌ 无法读取剪贴板'); }
         }
+        // writeClipboardText 写入剪贴板，不可用时（如 HTTP 页面）弹出选中的文本供手动复制，返回是否已写入
+        async function writeClipboardText(t) {
+            if (!navigator.clipboard) {
+                showCopyFallback(t);
+                return false;
+            }
+            await navigator.clipboard.writeText(t);
+            return true;
+        }
+        function showCopyFallback(t) {
+            const text = document.getElementById('copyFallbackText');
+            text.value = t;
+            document.getElementById('copyFallbackModal').classList.add('show');
+            text.focus();
+            text.select();
+        }
+        function closeCopyFallback() {
+            document.getElementById('copyFallbackModal').classList.remove('show');
+        }
         async function copyToClipboard(t) {
             try {
-                await navigator.clipboard.writeText(t);
+                if (await writeClipboardText(t)) showNotification('✅ 已复制到剪贴板');
+            } catch(e) { showNotification('❌ 复制失败'); }
+        }
+        async function copyItem(item) {
+            try {
+                await ensureFullContent(item);
+            } catch(e) { showNotification('❌ 复制失败'); return; }
+            if (!item.mime) { copyToClipboard(item.content); return; }
+            if (!navigator.clipboard) { showNotification('❌ 当前页面不支持复制图片'); return; }
+            try {
+                const blob = await (await fetch('data:' + item.mime + ';base64,' + item.content)).blob();
+                await navigator.clipboard.write([new ClipboardItem({[item.mime]: blob})]);
                 showNotification('✅ 已复制到剪贴板');
             } catch(e) { showNotification('❌ 复制失败'); }
         }
+        async function copyTransformed(id, op) {
+            try {
+                const r = await fetch(API_BASE + '/api/transform?id=' + id + '&op=' + op);
+                if (!r.ok) { showNotification('❌ 复制失败'); return; }
+                const data = await r.json();
+                if (await writeClipboardText(data.content)) {
+                    showNotification(data.changed ? '✅ 已复制处理后的内容' : '✅ 内容无需处理，已复制原文');
+                }
+            } catch(e) { showNotification('❌ 复制失败'); }
+        }
+        async function popItem(id) {
+            try {
+                const r = await fetch(API_BASE + '/api/pop', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({id: id})
+                });
+                const data = await r.json();
+                if (!r.ok || !data.success) { showNotification('❌ 操作失败'); return; }
+                loadItems();
+                if (await writeClipboardText(data.content)) showNotification('✅ 已复制并删除');
+            } catch(e) { showNotification('❌ 操作失败'); }
+        }
+        const QR_MAX_LENGTH = {{.QRMaxLength}};
+        function showQR(id) {
+            document.getElementById('qrImage').src = API_BASE + '/api/qr?id=' + id;
+            document.getElementById('qrModal').classList.add('show');
+        }
+        function closeQR() {
+            document.getElementById('qrModal').classList.remove('show');
+            document.getElementById('qrImage').removeAttribute('src');
+        }
+        const KEEP_HISTORY = {{.KeepHistory}};
+        async function showHistory(id) {
+            try {
+                const r = await fetch(API_BASE + '/api/item?id=' + id);
+                if (!r.ok) { showNotification('❌ 加载失败'); return; }
+                const item = await r.json();
+                const list = document.getElementById('historyList');
+                list.innerHTML = '';
+                const history = (item.history || []).slice().reverse();
+                if (history.length === 0) {
+                    list.innerHTML = '<li class="empty-message">暂无历史版本</li>';
+                }
+                history.forEach(content => {
+                    const li = document.createElement('li');
+                    li.className = 'history-entry';
+                    const text = document.createElement('span');
+                    text.textContent = content;
+                    const btn = document.createElement('button');
+                    btn.className = 'action-btn copy-btn';
+                    btn.textContent = '恢复';
+                    btn.onclick = () => restoreVersion(id, content);
+                    li.appendChild(text);
+                    li.appendChild(btn);
+                    list.appendChild(li);
+                });
+                document.getElementById('historyModal').classList.add('show');
+            } catch(e) { showNotification('❌ 加载失败'); }
+        }
+        function closeHistory() {
+            document.getElementById('historyModal').classList.remove('show');
+        }
+        async function restoreVersion(id, content) {
+            try {
+                const r = await fetch(API_BASE + '/api/update', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({id: id, content: content})
+                });
+                const data = await r.json();
+                showNotification(r.ok && data.success ? '✅ 已恢复' : '❌ 恢复失败');
+                closeHistory();
+                loadItems();
+            } catch(e) { showNotification('❌ 恢复失败'); }
+        }
+        let showArchived = false;
+        async function toggleArchive(id) {
+            try {
+                const r = await fetch(API_BASE + '/api/toggle-archive', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({id: id})
+                });
+                if (r.ok) loadItems(); else showNotification('❌ 操作失败');
+            } catch(e) { showNotification('❌ 操作失败'); }
+        }
+        function toggleArchivedView() {
+            showArchived = !showArchived;
+            document.getElementById('archivedToggle').textContent = showArchived ? '隐藏' : '显示';
+            document.getElementById('archivedList').style.display = showArchived ? '' : 'none';
+            if (showArchived) loadArchived();
+        }
+        async function loadArchived() {
+            try {
+                const r = await fetch(API_BASE + '/api/items?archived=true');
+                const items = (await r.json()) || [];
+                const list = document.getElementById('archivedList');
+                list.innerHTML = '';
+                if (items.length === 0) {
+                    list.innerHTML = '<li class="empty-message">暂无归档</li>';
+                }
+                items.forEach(item => list.appendChild(createItemElement(item)));
+            } catch(e) { console.error('加载失败:', e); }
+        }
+        let showTrash = false;
+        function toggleTrashView() {
+            showTrash = !showTrash;
+            document.getElementById('trashToggle').textContent = showTrash ? '隐藏' : '显示';
+            document.getElementById('trashList').style.display = showTrash ? '' : 'none';
+            if (showTrash) loadTrash();
+        }
+        async function loadTrash() {
+            try {
+                const r = await fetch(API_BASE + '/api/trash');
+                const items = (await r.json()) || [];
+                const list = document.getElementById('trashList');
+                list.innerHTML = '';
+                if (items.length === 0) {
+                    list.innerHTML = '<li class="empty-message">回收站为空</li>';
+                }
+                items.forEach(item => {
+                    const li = document.createElement('li');
+                    li.className = 'clipboard-item';
+                    const contentDiv = document.createElement('div');
+                    contentDiv.className = 'item-content';
+                    contentDiv.textContent = item.mime ? '[' + item.mime + ']' : item.content;
+                    const restoreBtn = document.createElement('button');
+                    restoreBtn.className = 'action-btn copy-btn';
+                    restoreBtn.textContent = '恢复';
+                    restoreBtn.onclick = () => restoreItem(item.id);
+                    li.appendChild(contentDiv);
+                    li.appendChild(restoreBtn);
+                    list.appendChild(li);
+                });
+            } catch(e) { console.error('加载失败:', e); }
+        }
+        async function restoreItem(id) {
+            try {
+                const r = await fetch(API_BASE + '/api/restore', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({id: id})
+                });
+                if (r.ok) { showNotification('✅ 已恢复'); loadItems(); loadTrash(); } else showNotification('❌ 操作失败');
+            } catch(e) { showNotification('❌ 操作失败'); }
+        }
+        async function purgeTrash() {
+            if (!confirm('确定要永久删除回收站中的所有内容吗？')) return;
+            try {
+                const r = await fetch(API_BASE + '/api/trash/purge', {method: 'POST'});
+                if (r.ok) { showNotification('🗑️ 回收站已清空'); loadTrash(); } else showNotification('❌ 操作失败');
+            } catch(e) { showNotification('❌ 操作失败'); }
+        }
         async function togglePin(id) {
             try {
-                const r = await fetch('/api/toggle-pin', {
+                const r = await fetch(API_BASE + '/api/toggle-pin', {
                     method: 'POST',
                     headers: {'Content-Type': 'application/json'},
                     body: JSON.stringify({id: id})
                 });
+                if (r.ok) loadItems();
+                else if (r.status === 409) showNotification('⚠️ 置顶数量已达上限，请先取消其他置顶');
+                else showNotification('❌ 操作失败');
+            } catch(e) { showNotification('❌ 操作失败'); }
+        }
+        // movePinned 将置顶条目在置顶列表中上移（delta 为 -1）或下移（delta 为 1）
+        async function movePinned(id, delta) {
+            const pinned = currentItems.filter(i => i.pinned);
+            const index = pinned.findIndex(i => i.id === id) + delta;
+            if (index < 0 || index >= pinned.length) return;
+            try {
+                const r = await fetch(API_BASE + '/api/reorder-pinned', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({id: id, index: index})
+                });
                 if (r.ok) loadItems(); else showNotification('❌ 操作失败');
             } catch(e) { showNotification('❌ 操作失败'); }
         }
-        function toggleExpand(el) {
+        // ensureFullContent 预览模式下内容可能被截断，需要时再获取完整内容
+        async function ensureFullContent(item) {
+            if (!item.truncated) return item;
+            const r = await fetch(API_BASE + '/api/item?id=' + item.id);
+            if (!r.ok) throw new Error('加载失败');
+            const full = await r.json();
+            item.content = full.content;
+            item.truncated = false;
+            return item;
+        }
+        async function toggleExpand(el, item) {
+            if (item && item.truncated) {
+                try {
+                    await ensureFullContent(item);
+                    el.textContent = item.content;
+                } catch(e) { showNotification('❌ 加载失败'); return; }
+            }
             el.classList.toggle('truncated');
             el.classList.toggle('expanded');
         }
@@ -663,22 +4601,70 @@ const htmlContent = `<!DOCTYPE html>
                 refreshTimer = null;
             }
         }
+        const KIND_LABELS = {'url': '链接', 'email': '邮箱', 'hex-color': '颜色', 'json': 'JSON'};
+        function createKindBadge(item) {
+            const badge = document.createElement('span');
+            badge.className = 'kind-badge kind-' + item.kind;
+            if (item.kind === 'hex-color') {
+                const swatch = document.createElement('span');
+                swatch.className = 'color-swatch';
+                swatch.style.background = item.content.trim();
+                badge.appendChild(swatch);
+            }
+            badge.appendChild(document.createTextNode(KIND_LABELS[item.kind]));
+            return badge;
+        }
         function createItemElement(item) {
             const li = document.createElement('li');
             li.className = 'clipboard-item' + (item.pinned ? ' pinned' : '');
+            const tips = [];
+            if (item.source) tips.push('来源: ' + item.source);
+            if (item.copy_count > 1) tips.push('粘贴 ' + item.copy_count + ' 次');
+            if (tips.length) li.title = tips.join('\n');
+            const bodyDiv = document.createElement('div');
+            bodyDiv.className = 'item-body';
+            if (KIND_LABELS[item.kind]) bodyDiv.appendChild(createKindBadge(item));
             const contentDiv = document.createElement('div');
             contentDiv.className = 'item-content';
-            if (item.content.length > TRUNCATE_LENGTH) {
-                contentDiv.classList.add('truncated');
-                contentDiv.onclick = () => toggleExpand(contentDiv);
+            if (item.mime && item.mime.startsWith('image/')) {
+                const img = document.createElement('img');
+                img.className = 'item-image';
+                ensureFullContent(item).then(() => {
+                    img.src = 'data:' + item.mime + ';base64,' + item.content;
+                }).catch(e => console.error('加载失败:', e));
+                contentDiv.appendChild(img);
+            } else if (item.mime) {
+                contentDiv.textContent = '[' + item.mime + ']';
+            } else {
+                if (item.truncated || item.content.length > TRUNCATE_LENGTH) {
+                    contentDiv.classList.add('truncated');
+                    contentDiv.onclick = () => toggleExpand(contentDiv, item);
+                }
+                contentDiv.textContent = item.content;
             }
-            contentDiv.textContent = item.content;
+            bodyDiv.appendChild(contentDiv);
             const btnGroup = document.createElement('div');
             btnGroup.className = 'button-group';
             const copyBtn = document.createElement('button');
             copyBtn.className = 'action-btn copy-btn';
             copyBtn.textContent = '复制';
-            copyBtn.onclick = () => copyToClipboard(item.content);
+            copyBtn.onclick = () => copyItem(item);
+            const popBtn = document.createElement('button');
+            popBtn.className = 'action-btn pop-btn';
+            popBtn.textContent = '复制并删除';
+            popBtn.onclick = () => popItem(item.id);
+            const qrBtn = document.createElement('button');
+            qrBtn.className = 'action-btn qr-btn';
+            qrBtn.textContent = '二维码';
+            qrBtn.onclick = () => showQR(item.id);
+            const historyBtn = document.createElement('button');
+            historyBtn.className = 'action-btn history-btn';
+            historyBtn.textContent = '历史';
+            historyBtn.onclick = () => showHistory(item.id);
+            const archiveBtn = document.createElement('button');
+            archiveBtn.className = 'action-btn archive-btn';
+            archiveBtn.textContent = item.archived ? '取消归档' : '归档';
+            archiveBtn.onclick = () => toggleArchive(item.id);
             const pinBtn = document.createElement('button');
             pinBtn.className = 'action-btn pin-btn' + (item.pinned ? ' pinned' : '');
             pinBtn.textContent = item.pinned ? '取消置顶' : '置顶';
@@ -688,37 +4674,158 @@ const htmlContent = `<!DOCTYPE html>
             delBtn.textContent = '删除';
             delBtn.onclick = () => showDeleteModal(item.id);
             btnGroup.appendChild(copyBtn);
+            if (item.kind === 'url' && item.content.includes('?')) {
+                const cleanBtn = document.createElement('button');
+                cleanBtn.className = 'action-btn copy-btn';
+                cleanBtn.textContent = '复制净链接';
+                cleanBtn.title = '去掉 utm_* 等跟踪参数后复制';
+                cleanBtn.onclick = () => copyTransformed(item.id, 'strip-tracking');
+                btnGroup.appendChild(cleanBtn);
+            }
+            if (!item.pinned && !item.mime) btnGroup.appendChild(popBtn);
+            const byteLength = item.truncated ? item.length : new TextEncoder().encode(item.content).length;
+            if (!item.mime && byteLength <= QR_MAX_LENGTH) btnGroup.appendChild(qrBtn);
+            if (KEEP_HISTORY && !item.mime) btnGroup.appendChild(historyBtn);
+            if (item.pinned && !item.archived) {
+                const upBtn = document.createElement('button');
+                upBtn.className = 'action-btn pin-btn';
+                upBtn.textContent = '↑';
+                upBtn.title = '上移';
+                upBtn.onclick = () => movePinned(item.id, -1);
+                const downBtn = document.createElement('button');
+                downBtn.className = 'action-btn pin-btn';
+                downBtn.textContent = '↓';
+                downBtn.title = '下移';
+                downBtn.onclick = () => movePinned(item.id, 1);
+                btnGroup.appendChild(upBtn);
+                btnGroup.appendChild(downBtn);
+            }
             btnGroup.appendChild(pinBtn);
+            btnGroup.appendChild(archiveBtn);
             btnGroup.appendChild(delBtn);
-            li.appendChild(contentDiv);
+            li.appendChild(bodyDiv);
             li.appendChild(btnGroup);
             return li;
         }
-        async function loadItems(silent = false) {
+        let currentItems = [];
+        let currentRevision = null;
+        function applyChange(ev) {
+            currentRevision = ev.revision;
+            switch (ev.type) {
+                case 'snapshot':
+                    currentItems = ev.items || [];
+                    break;
+                case 'added':
+                case 'moved':
+                    currentItems = currentItems.filter(i => i.id !== ev.item.id);
+                    currentItems.unshift(ev.item);
+                    break;
+                case 'updated':
+                    if (ev.item.archived) {
+                        currentItems = currentItems.filter(i => i.id !== ev.item.id);
+                    } else if (currentItems.some(i => i.id === ev.item.id)) {
+                        currentItems = currentItems.map(i => i.id === ev.item.id ? ev.item : i);
+                    } else {
+                        currentItems.unshift(ev.item);
+                    }
+                    break;
+                case 'deleted':
+                    currentItems = currentItems.filter(i => i.id !== ev.id);
+                    break;
+            }
+            refreshView();
+        }
+        const SEARCH_DEBOUNCE = 300;
+        let searchQuery = '';
+        let searchTimer = null;
+        function onSearchInput() {
+            clearTimeout(searchTimer);
+            searchTimer = setTimeout(() => {
+                searchQuery = document.getElementById('searchInput').value.trim();
+                refreshView();
+            }, SEARCH_DEBOUNCE);
+        }
+        async function runSearch() {
+            const q = searchQuery;
             try {
-                const r = await fetch('/api/items');
+                const r = await fetch(API_BASE + '/api/search?q=' + encodeURIComponent(q));
                 const items = await r.json();
-                const normalList = document.getElementById('normalList');
-                const pinnedList = document.getElementById('pinnedList');
-                const normalItems = (items || []).filter(i => !i.pinned);
-                const pinnedItems = (items || []).filter(i => i.pinned);
-                document.getElementById('normalCount').textContent = normalItems.length;
-                document.getElementById('pinnedCount').textContent = pinnedItems.length;
-                if (normalItems.length === 0) {
-                    normalList.innerHTML = '<li class="empty-message">暂无内容</li>';
-                } else {
-                    normalList.innerHTML = '';
-                    normalItems.forEach(item => normalList.appendChild(createItemElement(item)));
-                }
-                if (pinnedItems.length === 0) {
-                    pinnedList.innerHTML = '<li class="empty-message">暂无置顶</li>';
-                } else {
-                    pinnedList.innerHTML = '';
-                    pinnedItems.forEach(item => pinnedList.appendChild(createItemElement(item)));
+                // 丢弃已过时的搜索结果
+                if (q === searchQuery) renderItems(items);
+            } catch(e) { console.error('搜索失败:', e); }
+        }
+        function refreshView() {
+            if (searchQuery) runSearch(); else renderItems(currentItems);
+            if (showArchived) loadArchived();
+            if (showTrash) loadTrash();
+        }
+        document.addEventListener('keydown', (e) => {
+            const tag = document.activeElement ? document.activeElement.tagName : '';
+            if (tag === 'INPUT' || tag === 'TEXTAREA') {
+                if (e.key === 'Escape' && document.activeElement.id === 'searchInput') {
+                    document.activeElement.value = '';
+                    document.activeElement.blur();
+                    onSearchInput();
                 }
+                return;
+            }
+            if (e.key === '/') {
+                e.preventDefault();
+                document.getElementById('searchInput').focus();
+            } else if (e.key === 'v' && (e.ctrlKey || e.metaKey)) {
+                e.preventDefault();
+                pasteFromClipboard();
+            }
+        });
+        function connectWebSocket() {
+            const ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + API_BASE + '/ws');
+            ws.onmessage = (e) => applyChange(JSON.parse(e.data));
+            ws.onclose = () => setTimeout(connectWebSocket, 3000);
+        }
+        // 窄屏设备请求更短的预览，减少传输和渲染量
+        const MOBILE_PREVIEW_LENGTH = Math.min(TRUNCATE_LENGTH, 200);
+        async function loadItems(silent = false) {
+            try {
+                let url = API_BASE + '/api/items?preview=true';
+                if (window.matchMedia('(max-width: 768px)').matches) url += '&maxlen=' + MOBILE_PREVIEW_LENGTH;
+                if (silent && currentRevision !== null) url += '&since=' + currentRevision;
+                const r = await fetch(url);
+                if (r.status === 304) return;
+                currentRevision = r.headers.get('X-Revision');
+                currentItems = (await r.json()) || [];
+                refreshView();
             } catch(e) { console.error('加载失败:', e); }
         }
+        function renderItems(items) {
+            const normalList = document.getElementById('normalList');
+            const pinnedList = document.getElementById('pinnedList');
+            const normalItems = (items || []).filter(i => !i.pinned);
+            const pinnedItems = (items || []).filter(i => i.pinned);
+            document.getElementById('normalCount').textContent = normalItems.length;
+            document.getElementById('pinnedCount').textContent = pinnedItems.length;
+            if (normalItems.length === 0) {
+                normalList.innerHTML = '<li class="empty-message">暂无内容</li>';
+            } else {
+                normalList.innerHTML = '';
+                normalItems.forEach(item => normalList.appendChild(createItemElement(item)));
+            }
+            if (pinnedItems.length === 0) {
+                pinnedList.innerHTML = '<li class="empty-message">暂无置顶</li>';
+            } else {
+                pinnedList.innerHTML = '';
+                pinnedItems.forEach(item => pinnedList.appendChild(createItemElement(item)));
+            }
+        }
+        async function loadVersion() {
+            try {
+                const r = await fetch(API_BASE + '/api/version');
+                const data = await r.json();
+                document.getElementById('versionFooter').textContent = 'easyCopy v' + data.version;
+            } catch(e) {}
+        }
         loadItems();
+        loadVersion();
+        connectWebSocket();
     </script>
 </body>
 </html>`
@@ -0,0 +1,2270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"maps"
+	"math/big"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestManager 创建使用临时目录中数据文件的管理器
+func newTestManager(t *testing.T) *ClipboardManager {
+	t.Helper()
+	return NewClipboardManager(filepath.Join(t.TempDir(), "clipboard_data.txt"))
+}
+
+// setFlag 在当前测试期间修改命令行参数的值，测试结束后恢复
+func setFlag[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func TestDetectKind(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"https://example.com/path?q=1", KindURL},
+		{"  http://example.com  ", KindURL},
+		{"https://example.com with text", KindText},
+		{"user@example.com", KindEmail},
+		{"user@localhost", KindText},
+		{"#fff", KindHexColor},
+		{"#A1b2C3d4", KindHexColor},
+		{"#12345", KindText},
+		{`{"a": 1}`, KindJSON},
+		{"[1, 2, 3]", KindJSON},
+		{"{not json}", KindText},
+		{"plain text", KindText},
+		{"", KindText},
+	}
+	for _, tt := range tests {
+		if got := detectKind(tt.content); got != tt.want {
+			t.Errorf("detectKind(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestDeleteAllThenReloadKeepsNextID(t *testing.T) {
+	cm := newTestManager(t)
+	for _, c := range []string{"a", "b", "c"} {
+		cm.AddItem(c)
+	}
+	for _, item := range cm.GetItems() {
+		cm.DeleteItem(item.ID)
+	}
+	cm.PurgeTrash()
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewClipboardManager(cm.dataFile)
+	if err := loaded.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(loaded.GetItems()); n != 0 {
+		t.Fatalf("重新加载后有 %d 条记录，期望为空", n)
+	}
+	item, _ := loaded.AddItem("d")
+	if item.ID != 4 {
+		t.Fatalf("新条目 ID = %d，期望 4（不能复用已删除的 ID）", item.ID)
+	}
+}
+
+func TestLoadZeroByteFile(t *testing.T) {
+	cm := newTestManager(t)
+	if err := os.WriteFile(cm.dataFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := cm.AddItem("a"); item.ID != 1 {
+		t.Fatalf("ID = %d，期望 1", item.ID)
+	}
+}
+
+// useTransformers 按当前参数重新生成处理链，测试结束后恢复
+func useTransformers(t *testing.T) {
+	t.Helper()
+	setFlag(t, &transformers, buildTransformers())
+}
+
+func TestNormalizeNewlinesDedupe(t *testing.T) {
+	setFlag(t, normalizeNewlines, true)
+	useTransformers(t)
+	cm := newTestManager(t)
+
+	first, _ := cm.AddItem("line1\r\nline2\r\n")
+	if first.Content != "line1\nline2\n" {
+		t.Fatalf("存储的内容 = %q，期望换行已统一为 LF", first.Content)
+	}
+	second, existed := cm.AddItem("line1\nline2\n")
+	if !existed || second.ID != first.ID {
+		t.Fatalf("LF 版本应与 CRLF 版本去重，得到 existed=%v id=%d", existed, second.ID)
+	}
+	// 混合换行同样规范化；单独的 CR 不属于 CRLF，保持不变
+	mixed, existed := cm.AddItem("line1\r\nline2\n")
+	if !existed || mixed.ID != first.ID {
+		t.Fatalf("混合换行应与已有条目去重，得到 existed=%v id=%d", existed, mixed.ID)
+	}
+	if item, _ := cm.AddItem("a\rb"); item.Content != "a\rb" {
+		t.Fatalf("单独的 CR 被修改: %q", item.Content)
+	}
+}
+
+func TestNormalizeNewlinesDisabled(t *testing.T) {
+	setFlag(t, normalizeNewlines, false)
+	useTransformers(t)
+	cm := newTestManager(t)
+
+	cm.AddItem("a\r\nb")
+	if _, existed := cm.AddItem("a\nb"); existed {
+		t.Fatal("未开启 -normalize-newlines 时 CRLF 和 LF 应视为不同内容")
+	}
+}
+
+// useTestManager 将默认列表替换为临时管理器，供直接调用的处理函数使用
+func useTestManager(t *testing.T) *ClipboardManager {
+	t.Helper()
+	cm := newTestManager(t)
+	setFlag(t, &clipboardManager, cm)
+	return cm
+}
+
+// doRequest 直接调用处理函数并返回响应
+func doRequest(h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestAddTTL(t *testing.T) {
+	cm := useTestManager(t)
+
+	rec := doRequest(handleAdd, http.MethodPost, "/api/add", `{"content":"a","ttl_seconds":60}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	item := cm.GetItems()[0]
+	if item.ExpiresAt == nil || time.Until(*item.ExpiresAt) < 50*time.Second {
+		t.Fatalf("ExpiresAt = %v，期望约 60 秒后", item.ExpiresAt)
+	}
+
+	// 超出上限的值会溢出 time.Duration，必须拒绝而不是让条目立刻过期
+	rec = doRequest(handleAdd, http.MethodPost, "/api/add", `{"content":"b","ttl_seconds":9223372036}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_ttl") {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if n := len(cm.GetItems()); n != 1 {
+		t.Fatalf("被拒绝的请求不应添加条目，当前 %d 条", n)
+	}
+
+	// 负数不能被当作“不设有效期”静默接受
+	rec = doRequest(handleAdd, http.MethodPost, "/api/add", `{"content":"c","ttl_seconds":-1}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_ttl") {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if n := len(cm.GetItems()); n != 1 {
+		t.Fatalf("被拒绝的请求不应添加条目，当前 %d 条", n)
+	}
+}
+
+func TestRemoveExpiredSkipsPinned(t *testing.T) {
+	cm := newTestManager(t)
+	pinned, _ := cm.AddItem("pinned")
+	plain, _ := cm.AddItem("plain")
+	cm.SetPin(pinned.ID, true)
+	past := time.Now().Add(-time.Minute)
+	cm.SetExpiry(pinned.ID, &past)
+	cm.SetExpiry(plain.ID, &past)
+
+	if n := cm.RemoveExpired(time.Now()); n != 1 {
+		t.Fatalf("删除了 %d 条，期望 1", n)
+	}
+	if _, ok := cm.GetItem(pinned.ID); !ok {
+		t.Fatal("置顶项不应过期")
+	}
+}
+
+func TestMethodNotAllowedSetsAllow(t *testing.T) {
+	useTestManager(t)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		allow   string
+	}{
+		{"items", handleItems, http.MethodPost, http.MethodGet},
+		{"add", handleAdd, http.MethodGet, http.MethodPost},
+		{"delete", handleDelete, http.MethodGet, http.MethodPost},
+		{"toggle-pin", handleTogglePin, http.MethodPut, http.MethodPost},
+	}
+	for _, tt := range tests {
+		rec := doRequest(tt.handler, tt.method, "/api/"+tt.name, "")
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: status = %d, want 405", tt.name, rec.Code)
+		}
+		if got := rec.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s: Allow = %q, want %q", tt.name, got, tt.allow)
+		}
+	}
+}
+
+func TestWebSocketMessagesAreRateLimited(t *testing.T) {
+	cm := newTestManager(t)
+	limiter := NewRateLimiter(0.001) // 桶容量为 1，测试期间不会补充令牌
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+
+	handleWebSocketMessage(r, cm, limiter, wsMessage{Action: "add", Content: "a"})
+	handleWebSocketMessage(r, cm, limiter, wsMessage{Action: "add", Content: "b"})
+	if n := len(cm.GetItems()); n != 1 {
+		t.Fatalf("限流后应只添加 1 条，实际 %d 条", n)
+	}
+
+	handleWebSocketMessage(r, cm, nil, wsMessage{Action: "add", Content: "c"})
+	if n := len(cm.GetItems()); n != 2 {
+		t.Fatalf("未启用限流时应正常添加，实际 %d 条", n)
+	}
+}
+
+// reload 保存后用同一数据文件创建新的管理器并加载
+func reload(t *testing.T, cm *ClipboardManager) *ClipboardManager {
+	t.Helper()
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewClipboardManager(cm.dataFile)
+	if err := loaded.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestSanitizeStripsControlBytes(t *testing.T) {
+	setFlag(t, sanitize, true)
+	useTransformers(t)
+	cm := newTestManager(t)
+
+	item, _ := cm.AddItem("a\x00b\x07c\td\r\ne\x1b[0m|f\x7f")
+	want := "abc\td\r\ne[0m|f"
+	if item.Content != want {
+		t.Fatalf("Content = %q, want %q", item.Content, want)
+	}
+
+	// 内容以 base64 保存，包含 | 和换行也能原样读回
+	got, ok := reload(t, cm).GetItem(item.ID)
+	if !ok || got.Content != want {
+		t.Fatalf("重新加载后 Content = %q, want %q", got.Content, want)
+	}
+}
+
+func TestNamespaceLimit(t *testing.T) {
+	setFlag(t, &dataDir, t.TempDir())
+	nr := NewNamespaceRegistry(2)
+	handler := nr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	for _, path := range []string{"/u/alice/", "/u/bob/api/items", "/u/alice/api/items"} {
+		if code := get(path); code != http.StatusNoContent {
+			t.Fatalf("GET %s = %d, want 204", path, code)
+		}
+	}
+	if code := get("/u/carol/"); code != http.StatusServiceUnavailable {
+		t.Fatalf("超过上限后创建新命名空间 = %d, want 503", code)
+	}
+	if code := get("/u/bob/"); code != http.StatusNoContent {
+		t.Fatalf("已加载的命名空间应仍可访问，得到 %d", code)
+	}
+	if n := len(nr.managers); n != 2 {
+		t.Fatalf("加载了 %d 个命名空间，期望 2", n)
+	}
+}
+
+func TestValidMimeType(t *testing.T) {
+	valid := []string{"image/png", "text/plain; charset=utf-8", "application/vnd.example+json"}
+	invalid := []string{"png", "image/png\n999|true|ZXZpbA==", "image/png|x", "image/png, text/plain", "image/\x00png", ""}
+	for _, v := range valid {
+		if !validMimeType(v) {
+			t.Errorf("validMimeType(%q) = false, want true", v)
+		}
+	}
+	for _, v := range invalid {
+		if validMimeType(v) {
+			t.Errorf("validMimeType(%q) = true, want false", v)
+		}
+	}
+}
+
+func TestAddRejectsInvalidMime(t *testing.T) {
+	cm := useTestManager(t)
+	rec := doRequest(handleAdd, http.MethodPost, "/api/add", `{"content":"aGk=","mime":"image/png\n999|true|ZXZpbA=="}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_mime") {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if n := len(cm.GetItems()); n != 0 {
+		t.Fatalf("被拒绝的请求不应添加条目，当前 %d 条", n)
+	}
+
+	// 一张 2x2 的真实 PNG 图片，保存并重新加载后字节、类型和 Kind 都应保持不变
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	img.Set(1, 1, color.RGBA{B: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]string{"content": base64.StdEncoding.EncodeToString(buf.Bytes()), "mime": "image/png"})
+	rec = doRequest(handleAdd, http.MethodPost, "/api/add", string(body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	got := reload(t, cm).GetItems()
+	if len(got) != 1 || got[0].MimeType != "image/png" || got[0].Kind != KindBlob {
+		t.Fatalf("重新加载后 = %+v", got)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(got[0].Content)
+	if err != nil {
+		t.Fatalf("重新加载后的内容不是合法的 base64: %v", err)
+	}
+	if !bytes.Equal(decoded, buf.Bytes()) {
+		t.Fatal("重新加载后的图片字节与原图不同")
+	}
+	if _, err := png.Decode(bytes.NewReader(decoded)); err != nil {
+		t.Fatalf("重新加载后的图片无法解码: %v", err)
+	}
+}
+
+func TestSetPinIsIdempotent(t *testing.T) {
+	cm := newTestManager(t)
+	item, _ := cm.AddItem("a")
+
+	for i := 0; i < 2; i++ {
+		if ok, err := cm.SetPin(item.ID, true); !ok || err != nil {
+			t.Fatalf("第 %d 次置顶: ok=%v err=%v", i+1, ok, err)
+		}
+		if got, _ := cm.GetItem(item.ID); !got.Pinned {
+			t.Fatalf("第 %d 次置顶后条目未置顶", i+1)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		cm.SetPin(item.ID, false)
+		if got, _ := cm.GetItem(item.ID); got.Pinned {
+			t.Fatalf("第 %d 次取消置顶后条目仍置顶", i+1)
+		}
+	}
+	if ok, _ := cm.SetPin(999, true); ok {
+		t.Fatal("不存在的 ID 应返回 false")
+	}
+}
+
+func TestPinHandler(t *testing.T) {
+	cm := useTestManager(t)
+	item, _ := cm.AddItem("a")
+	body := fmt.Sprintf(`{"id":%d,"pinned":true}`, item.ID)
+	for i := 0; i < 2; i++ {
+		if rec := doRequest(handleSetPin, http.MethodPost, "/api/pin", body); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+	}
+	if got, _ := cm.GetItem(item.ID); !got.Pinned {
+		t.Fatal("连续两次设置置顶后条目应保持置顶")
+	}
+}
+
+func TestIsCorruptFile(t *testing.T) {
+	tests := []struct {
+		good, bad int
+		want      bool
+	}{
+		{5, 0, false},
+		{0, 1, true},
+		{1, 1, false},
+		{1, 8, false},
+		{1, 9, true},
+		{2, 8, false},
+	}
+	for _, tt := range tests {
+		if got := isCorruptFile(tt.good, tt.bad); got != tt.want {
+			t.Errorf("isCorruptFile(%d, %d) = %v, want %v", tt.good, tt.bad, got, tt.want)
+		}
+	}
+}
+
+func TestLoadGarbageFileIsBackedUp(t *testing.T) {
+	cm := newTestManager(t)
+	garbage := "\x00\x01garbage\nnot|a|record!\n???\n"
+	if err := os.WriteFile(cm.dataFile, []byte(garbage), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cm.GetItems()); n != 0 {
+		t.Fatalf("损坏的文件加载了 %d 条记录", n)
+	}
+	backup, err := os.ReadFile(cm.dataFile + ".bak")
+	if err != nil || string(backup) != garbage {
+		t.Fatalf("备份内容不一致: %q, %v", backup, err)
+	}
+	if _, err := os.Stat(cm.dataFile); !os.IsNotExist(err) {
+		t.Fatalf("原文件应已被重命名: %v", err)
+	}
+}
+
+func TestLoadKeepsGoodRecordsInSmallFile(t *testing.T) {
+	cm := newTestManager(t)
+	data := "#version=6\n#next-id=3\n1|false|YQ==|text|||||||0|0|1\nbroken line\n"
+	if err := os.WriteFile(cm.dataFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if items := cm.GetItems(); len(items) != 1 || items[0].Content != "a" {
+		t.Fatalf("应保留正常的记录，得到 %+v", items)
+	}
+	if _, err := os.Stat(cm.dataFile + ".bak"); !os.IsNotExist(err) {
+		t.Fatal("只有一条坏记录时不应备份并清空")
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	setFlag(t, compressData, true)
+	cm := newTestManager(t)
+	long := strings.Repeat("compressible text ", 1000)
+	cm.AddItem(long)
+	cm.AddItem("short")
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(cm.dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, gzipMagic) {
+		t.Fatal("开启 -compress 后数据文件应为 gzip 格式")
+	}
+	if len(raw) >= len(long) {
+		t.Fatalf("压缩后 %d 字节，未比原文小", len(raw))
+	}
+
+	items := reload(t, cm).GetItems()
+	if len(items) != 2 || items[0].Content != "short" || items[1].Content != long {
+		t.Fatalf("重新加载的内容不一致: %d 条", len(items))
+	}
+}
+
+func TestCompressReadsLegacyPlainFile(t *testing.T) {
+	cm := newTestManager(t)
+	cm.AddItem("legacy")
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, compressData, true)
+	loaded := NewClipboardManager(cm.dataFile)
+	if err := loaded.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if items := loaded.GetItems(); len(items) != 1 || items[0].Content != "legacy" {
+		t.Fatalf("开启 -compress 后读取未压缩文件得到 %+v", items)
+	}
+}
+
+// TestConcurrentAddDeleteSave 用 go test -race 运行时可检查并发读写和保存是否存在数据竞争
+func TestConcurrentAddDeleteSave(t *testing.T) {
+	cm := newTestManager(t)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				item, _ := cm.AddItem(fmt.Sprintf("g%d-%d", g, i%20))
+				if i%3 == 0 {
+					cm.DeleteItem(item.ID)
+				}
+				if i%5 == 0 {
+					cm.TogglePin(item.ID)
+				}
+				cm.GetItems()
+				if err := cm.SaveToFile(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	want := cm.ExportItems()
+	got := reload(t, cm).ExportItems()
+	if len(got) != len(want) {
+		t.Fatalf("重新加载后 %d 条，内存中 %d 条", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].Content != want[i].Content || got[i].Pinned != want[i].Pinned {
+			t.Fatalf("第 %d 条不一致: %+v vs %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestContentIndexKeepsSurvivingDuplicate(t *testing.T) {
+	cm := newTestManager(t)
+	a, _ := cm.AddItem("a")
+	x, _ := cm.AddItem("x")
+	cm.UpdateItem(a.ID, "x") // 现在有两个内容为 x 的条目
+	cm.DeleteItem(x.ID)
+
+	item, existed := cm.AddItem("x")
+	if !existed || item.ID != a.ID {
+		t.Fatalf("应合并到剩下的 x 条目 %d，得到 id=%d existed=%v", a.ID, item.ID, existed)
+	}
+	if n := len(cm.GetItems()); n != 1 {
+		t.Fatalf("列表中有 %d 条，期望 1", n)
+	}
+}
+
+// checkContentIndex 校验内容索引与列表一致：每个条目都在索引中，索引中没有多余的 ID
+func checkContentIndex(t *testing.T, cm *ClipboardManager) {
+	t.Helper()
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	indexed := 0
+	for key, ids := range cm.contentIndex {
+		for _, id := range ids {
+			i, ok := cm.indexOf(id)
+			if !ok {
+				t.Fatalf("内容索引中的 ID %d 不在列表中", id)
+			}
+			if contentKey(cm.items[i].Content, cm.items[i].MimeType) != key {
+				t.Fatalf("ID %d 的内容与索引键不一致", id)
+			}
+			indexed++
+		}
+	}
+	if indexed != len(cm.items) {
+		t.Fatalf("内容索引中有 %d 个 ID，列表中有 %d 条", indexed, len(cm.items))
+	}
+}
+
+func TestContentIndexWithDuplicates(t *testing.T) {
+	cm := newTestManager(t)
+	a, _ := cm.AddItem("a")
+	b, _ := cm.AddItem("b")
+	cm.AppendToItem(a.ID, "b", "")
+	cm.UpdateItem(a.ID, "b")
+	checkContentIndex(t, cm)
+
+	cm.DeleteItem(b.ID)
+	checkContentIndex(t, cm)
+	cm.RestoreItem(b.ID)
+	checkContentIndex(t, cm)
+
+	if removed := cm.Compact(); removed != 1 {
+		t.Fatalf("Compact 删除了 %d 条，期望 1", removed)
+	}
+	checkContentIndex(t, cm)
+}
+
+// BenchmarkDedupe10k 在 10000 条记录中查找重复内容，lookup 只测内容索引，repaste 包含把条目移到最前面的开销
+func BenchmarkDedupe10k(b *testing.B) {
+	cm := NewClipboardManager(filepath.Join(b.TempDir(), "clipboard_data.txt"))
+	for i := 0; i < 10000; i++ {
+		cm.AddItem(fmt.Sprintf("item %d", i))
+	}
+	b.Run("lookup", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cm.mu.RLock()
+			cm.hasDuplicate(fmt.Sprintf("item %d", i%10000), "")
+			cm.mu.RUnlock()
+		}
+	})
+	// baseline 为引入索引之前的逐条比较，用来对照 lookup 的耗时
+	b.Run("baseline", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			target := fmt.Sprintf("item %d", i%10000)
+			cm.mu.RLock()
+			for _, item := range cm.items {
+				if item.MimeType == "" && item.Content == target {
+					break
+				}
+			}
+			cm.mu.RUnlock()
+		}
+	})
+	b.Run("repaste", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cm.AddItem(fmt.Sprintf("item %d", i%10000))
+		}
+	})
+	b.Run("add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cm.AddItem(fmt.Sprintf("new %d", i))
+			cm.DeleteItem(cm.nextID - 1)
+		}
+	})
+}
+
+// TestIndexUntouchedByOtherMutations 添加、重新粘贴和删除只修改涉及的条目的索引项，
+// 其余条目的索引项保持不变，保证每次修改的开销与列表长度无关
+func TestIndexUntouchedByOtherMutations(t *testing.T) {
+	cm := newTestManager(t)
+	for i := 0; i < 100; i++ {
+		cm.AddItem(fmt.Sprintf("item %d", i))
+	}
+	snapshot := func() map[int]int64 {
+		cm.mu.RLock()
+		defer cm.mu.RUnlock()
+		return maps.Clone(cm.idIndex)
+	}
+	before := snapshot()
+
+	repasted, _ := cm.AddItem("item 0")
+	added, _ := cm.AddItem("brand new")
+	cm.DeleteItem(50)
+
+	after := snapshot()
+	for id, seq := range before {
+		if id == repasted.ID || id == 50 {
+			continue
+		}
+		if after[id] != seq {
+			t.Fatalf("条目 %d 的索引项从 %d 变为 %d", id, seq, after[id])
+		}
+	}
+	if _, ok := after[50]; ok {
+		t.Fatal("删除的条目仍在索引中")
+	}
+	if after[repasted.ID] == before[repasted.ID] {
+		t.Fatal("重新粘贴的条目应获得新的位置")
+	}
+	if _, ok := after[added.ID]; !ok {
+		t.Fatal("新条目不在索引中")
+	}
+	checkIDIndex(t, cm)
+}
+
+func TestWebhookPayload(t *testing.T) {
+	received := make(chan map[string]any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer srv.Close()
+	setFlag(t, webhookURL, srv.URL)
+
+	cm := newTestManager(t)
+	item, _ := cm.AddItem("hello")
+
+	select {
+	case payload := <-received:
+		want := map[string]any{"id": float64(item.ID), "content": "hello", "pinned": false}
+		if len(payload) != len(want) {
+			t.Fatalf("payload = %v, want %v", payload, want)
+		}
+		for k, v := range want {
+			if payload[k] != v {
+				t.Fatalf("payload[%q] = %v, want %v", k, payload[k], v)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到 webhook 请求")
+	}
+
+	// 重新粘贴已有内容不是新增，不发送通知
+	cm.AddItem("hello")
+	select {
+	case payload := <-received:
+		t.Fatalf("重复内容不应触发 webhook: %v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookFailureDoesNotAffectAdd(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	srv.Close() // 连接会被拒绝
+	setFlag(t, webhookURL, srv.URL)
+
+	cm := newTestManager(t)
+	if _, existed := cm.AddItem("a"); existed || len(cm.GetItems()) != 1 {
+		t.Fatal("webhook 失败不应影响添加")
+	}
+}
+
+func TestOversizedBodyReturns413(t *testing.T) {
+	useTestManager(t)
+	setFlag(t, maxBodySize, int64(64))
+	big := `{"content":"` + strings.Repeat("x", 1000) + `"}`
+	for name, h := range map[string]http.HandlerFunc{
+		"add":    handleAdd,
+		"update": handleUpdate,
+		"delete": handleDelete,
+	} {
+		rec := doRequest(h, http.MethodPost, "/api/"+name, big)
+		if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "body_too_large") {
+			t.Errorf("%s: status = %d, body = %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := doRequest(handleAdd, http.MethodPost, "/api/add", `{"content":"ok"}`); rec.Code != http.StatusOK {
+		t.Fatalf("限制以内的请求应成功: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// decodeAPIError 解析统一格式的错误响应，格式不符时测试失败
+func decodeAPIError(t *testing.T, rec *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var body struct {
+		Error *apiError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == nil {
+		t.Fatalf("响应不是错误格式: %s", rec.Body)
+	}
+	return *body.Error
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	useTestManager(t)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		status  int
+		code    string
+	}{
+		{"malformed add", handleAdd, `{"content":`, http.StatusBadRequest, "invalid_json"},
+		{"missing content", handleAdd, `{}`, http.StatusBadRequest, "empty_content"},
+		{"missing id", handleDelete, `{}`, http.StatusBadRequest, "invalid_id"},
+		{"wrong type", handleDelete, `{"id":"1"}`, http.StatusBadRequest, "invalid_json"},
+	}
+	for _, tt := range tests {
+		rec := doRequest(tt.handler, http.MethodPost, "/", tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+			continue
+		}
+		if e := decodeAPIError(t, rec); e.Code != tt.code || e.Message == "" {
+			t.Errorf("%s: error = %+v, want code %q", tt.name, e, tt.code)
+		}
+	}
+}
+
+func TestSaveFailureEnvelope(t *testing.T) {
+	cm := NewClipboardManager(filepath.Join(t.TempDir(), "missing", "clipboard_data.txt"))
+	setFlag(t, &clipboardManager, cm)
+
+	rec := doRequest(handleAdd, http.MethodPost, "/api/add", `{"content":"a"}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if e := decodeAPIError(t, rec); e.Code != "save_failed" {
+		t.Fatalf("error = %+v", e)
+	}
+}
+
+// checkIDIndex 校验 ID 索引与列表一致
+func checkIDIndex(t *testing.T, cm *ClipboardManager) {
+	t.Helper()
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	if len(cm.idIndex) != len(cm.items) {
+		t.Fatalf("ID 索引有 %d 项，列表有 %d 条", len(cm.idIndex), len(cm.items))
+	}
+	for i, item := range cm.items {
+		if i > 0 && item.seq >= cm.items[i-1].seq {
+			t.Fatalf("下标 %d 的 seq %d 不小于前一条的 %d", i, item.seq, cm.items[i-1].seq)
+		}
+		if j, ok := cm.indexOf(item.ID); !ok || j != i {
+			t.Fatalf("ID %d 位于下标 %d，索引查到 %d (ok=%v)", item.ID, i, j, ok)
+		}
+	}
+}
+
+func TestIndexesStayConsistentUnderRandomOps(t *testing.T) {
+	setFlag(t, maxPinned, 5)
+	cm := newTestManager(t)
+	rng := rand.New(rand.NewPCG(1, 2))
+	randomID := func() int { return rng.IntN(cm.nextID+1) + 1 }
+
+	for step := 0; step < 3000; step++ {
+		switch rng.IntN(14) {
+		case 0, 1, 2:
+			cm.AddItem(fmt.Sprintf("c%d", rng.IntN(40)))
+		case 3:
+			cm.DeleteItem(randomID())
+		case 4:
+			cm.TogglePin(randomID())
+		case 5:
+			cm.SetPin(randomID(), rng.IntN(2) == 0)
+		case 6:
+			cm.UpdateItem(randomID(), fmt.Sprintf("c%d", rng.IntN(40)))
+		case 7:
+			cm.AppendToItem(randomID(), "x", "")
+		case 8:
+			cm.ToggleArchive(randomID())
+		case 9:
+			cm.RestoreItem(randomID())
+		case 10:
+			cm.MovePinned(randomID(), rng.IntN(5))
+		case 11:
+			cm.PopItem(randomID())
+		case 12:
+			cm.DeleteByContent(fmt.Sprintf("c%d", rng.IntN(40)))
+		case 13:
+			if rng.IntN(20) == 0 {
+				cm.Compact()
+			}
+		}
+		checkIDIndex(t, cm)
+		checkContentIndex(t, cm)
+	}
+}
+
+func TestArrangementSurvivesReload(t *testing.T) {
+	cm := newTestManager(t)
+	var ids []int
+	for i := 0; i < 6; i++ {
+		item, _ := cm.AddItem(fmt.Sprintf("item %d", i))
+		ids = append(ids, item.ID)
+	}
+	cm.SetPin(ids[1], true)
+	cm.SetPin(ids[4], true)
+	cm.SetPin(ids[2], true)
+	cm.MovePinned(ids[2], 0)
+	cm.AddItem("item 0") // 移到非置顶项最前面
+	cm.ToggleArchive(ids[3])
+
+	want := cm.GetItems()
+	wantArchived := cm.GetArchived()
+	loaded := reload(t, cm)
+	got := loaded.GetItems()
+	if len(got) != len(want) {
+		t.Fatalf("重新加载后 %d 条，期望 %d 条", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].Pinned != want[i].Pinned {
+			t.Fatalf("第 %d 条为 %d(pinned=%v)，期望 %d(pinned=%v)", i, got[i].ID, got[i].Pinned, want[i].ID, want[i].Pinned)
+		}
+	}
+	if archived := loaded.GetArchived(); len(archived) != len(wantArchived) || archived[0].ID != ids[3] {
+		t.Fatalf("归档条目不一致: %+v", archived)
+	}
+
+	// 再保存一次，文件内容应完全相同
+	first, _ := os.ReadFile(cm.dataFile)
+	loaded.dirty.Store(true)
+	if err := loaded.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := os.ReadFile(cm.dataFile)
+	if !bytes.Equal(first, second) {
+		t.Fatalf("重新保存后文件内容变化:\n%s\n---\n%s", first, second)
+	}
+}
+
+func TestNoPersistWritesNothing(t *testing.T) {
+	setFlag(t, noPersist, true)
+	dir := t.TempDir()
+	cm := NewClipboardManager(filepath.Join(dir, "clipboard_data.txt"))
+	if err := cm.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	item, _ := cm.AddItem("secret")
+	cm.DeleteItem(item.ID)
+	cm.AddItem("another")
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("-no-persist 时不应写入任何文件，目录中有 %d 个文件", len(entries))
+	}
+	if n := len(cm.GetItems()); n != 1 {
+		t.Fatalf("内存中应有 1 条，实际 %d 条", n)
+	}
+}
+
+func TestNoPersistIgnoresExistingFile(t *testing.T) {
+	cm := newTestManager(t)
+	cm.AddItem("on disk")
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, noPersist, true)
+	loaded := NewClipboardManager(cm.dataFile)
+	if err := loaded.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(loaded.GetItems()); n != 0 {
+		t.Fatalf("-no-persist 时不应读取已有文件，加载了 %d 条", n)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	useTestManager(t)
+	setFlag(t, authUser, "alice")
+	setFlag(t, authPass, "secret")
+	h := withBasicAuth("alice", "secret", http.HandlerFunc(handleAdd))
+
+	send := func(user, pass string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/api/add", strings.NewReader(`{"content":"hello"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cases := []struct {
+		name       string
+		user, pass string
+		header     map[string]string
+		want       int
+	}{
+		{"正确凭据", "alice", "secret", nil, http.StatusOK},
+		{"同源 Origin", "alice", "secret", map[string]string{"Origin": "http://example.com"}, http.StatusOK},
+		{"缺少凭据", "", "", nil, http.StatusUnauthorized},
+		{"密码错误", "alice", "wrong", nil, http.StatusUnauthorized},
+		{"用户名错误", "bob", "secret", nil, http.StatusUnauthorized},
+		{"跨站 Origin", "alice", "secret", map[string]string{"Origin": "http://evil.test"}, http.StatusForbidden},
+		{"跨站 Sec-Fetch-Site", "alice", "secret", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"非 JSON Content-Type", "alice", "secret", map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+	}
+	for _, tc := range cases {
+		rec := send(tc.user, tc.pass, tc.header)
+		if rec.Code != tc.want {
+			t.Errorf("%s: 状态码 %d，期望 %d: %s", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+		if tc.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 响应缺少 WWW-Authenticate", tc.name)
+		}
+	}
+}
+
+func TestBasicAuthRejectsCrossSiteQuickAdd(t *testing.T) {
+	cm := useTestManager(t)
+	h := withBasicAuth("alice", "secret", http.HandlerFunc(handleQuickAdd))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/quick-add?content=x", nil)
+	req.SetBasicAuth("alice", "secret")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("跨站 quick-add 状态码 %d，期望 403", rec.Code)
+	}
+	if len(cm.GetItems()) != 0 {
+		t.Fatal("被拒绝的 quick-add 不应添加条目")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	req.SetBasicAuth("alice", "secret")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	rec = httptest.NewRecorder()
+	withBasicAuth("alice", "secret", http.HandlerFunc(handleItems)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("只读请求不应被拒绝，状态码 %d", rec.Code)
+	}
+}
+
+func TestLargeItemWithHistorySurvivesReload(t *testing.T) {
+	setFlag(t, maxBodySize, int64(256<<10))
+	setFlag(t, keepHistory, true)
+	cm := newTestManager(t)
+
+	item, _ := cm.AddItem(strings.Repeat("0", 200<<10))
+	for i := 1; i <= maxHistoryLength; i++ {
+		cm.UpdateItem(item.ID, strings.Repeat(fmt.Sprint(i%10), 200<<10)+fmt.Sprint(i))
+	}
+	raw := longestLine(t, cm)
+	if raw <= minRecordLineSize {
+		t.Fatalf("测试数据应超过下限 %d 字节，实际 %d", minRecordLineSize, raw)
+	}
+
+	loaded := reload(t, cm)
+	got, ok := loaded.GetItem(item.ID)
+	if !ok {
+		t.Fatal("带完整历史的大条目在重新加载后丢失")
+	}
+	if len(got.History) != maxHistoryLength {
+		t.Fatalf("历史版本数 %d，期望 %d", len(got.History), maxHistoryLength)
+	}
+}
+
+// longestLine 返回保存后数据文件中最长一行的字节数
+func longestLine(t *testing.T, cm *ClipboardManager) int {
+	t.Helper()
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(cm.dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	longest := 0
+	for _, line := range strings.Split(string(raw), "\n") {
+		longest = max(longest, len(line))
+	}
+	return longest
+}
+
+func TestLoadSkipsOversizedLine(t *testing.T) {
+	setFlag(t, maxBodySize, int64(1024))
+	cm := newTestManager(t)
+	huge := strings.Repeat("A", maxRecordLineSize()+1)
+	data := "#version=6\n#next-id=3\n1|false|YQ==|text|||||||0|0|1\n" + huge + "\n2|false|Yg==|text|||||||0|0|2\n"
+	if err := os.WriteFile(cm.dataFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.LoadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	items := cm.GetItems()
+	if len(items) != 2 {
+		t.Fatalf("应跳过超长行并保留其余 2 条记录，得到 %d 条", len(items))
+	}
+}
+
+// pinnedIDs 返回置顶条目的 ID，按显示顺序
+func pinnedIDs(cm *ClipboardManager) []int {
+	var ids []int
+	for _, item := range cm.GetPinned() {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestMovePinned(t *testing.T) {
+	cm := newTestManager(t)
+	var ids []int
+	for i := 0; i < 5; i++ {
+		item, _ := cm.AddItem(fmt.Sprintf("item %d", i))
+		ids = append(ids, item.ID)
+	}
+	a, b, c, plain := ids[0], ids[1], ids[2], ids[3]
+	for _, id := range []int{a, b, c} {
+		cm.SetPin(id, true)
+	}
+	start := pinnedIDs(cm)
+
+	cases := []struct {
+		name  string
+		id    int
+		index int
+		ok    bool
+		want  []int
+	}{
+		{"移到最前", start[2], 0, true, []int{start[2], start[0], start[1]}},
+		{"移到中间", start[2], 1, true, []int{start[0], start[2], start[1]}},
+		{"移到末尾", start[0], 2, true, []int{start[1], start[2], start[0]}},
+		{"超出范围移到末尾", start[0], 99, true, []int{start[1], start[2], start[0]}},
+		{"原位置不变", start[1], 1, true, start},
+		{"未置顶的条目", plain, 0, false, start},
+		{"不存在的条目", 999, 0, false, start},
+	}
+	for _, tc := range cases {
+		cm := newTestManager(t)
+		for i := 0; i < 5; i++ {
+			cm.AddItem(fmt.Sprintf("item %d", i))
+		}
+		for _, id := range []int{a, b, c} {
+			cm.SetPin(id, true)
+		}
+		if ok := cm.MovePinned(tc.id, tc.index); ok != tc.ok {
+			t.Errorf("%s: 返回 %v，期望 %v", tc.name, ok, tc.ok)
+		}
+		if got := pinnedIDs(cm); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: 置顶顺序 %v，期望 %v", tc.name, got, tc.want)
+		}
+		checkIDIndex(t, cm)
+		if got := pinnedIDs(reload(t, cm)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: 重新加载后置顶顺序 %v，期望 %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReorderPinnedHandler(t *testing.T) {
+	cm := useTestManager(t)
+	first, _ := cm.AddItem("first")
+	second, _ := cm.AddItem("second")
+	cm.SetPin(first.ID, true)
+	cm.SetPin(second.ID, true)
+	before := pinnedIDs(cm)
+
+	rec := doRequest(handleReorderPinned, http.MethodPost, "/api/reorder-pinned", fmt.Sprintf(`{"id":%d,"index":-1}`, before[1]))
+	if rec.Code != http.StatusBadRequest || decodeAPIError(t, rec).Code != "invalid_index" {
+		t.Fatalf("负数位置应返回 400 invalid_index，得到 %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(handleReorderPinned, http.MethodPost, "/api/reorder-pinned", fmt.Sprintf(`{"id":%d,"index":0}`, before[1]))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"success":true`) {
+		t.Fatalf("移动失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if got := pinnedIDs(cm); !slices.Equal(got, []int{before[1], before[0]}) {
+		t.Fatalf("置顶顺序 %v", got)
+	}
+}
+
+func TestDeleteThenRestore(t *testing.T) {
+	cm := newTestManager(t)
+	item, _ := cm.AddItem("keep me")
+	other, _ := cm.AddItem("other")
+	cm.SetPin(item.ID, true)
+
+	if !cm.DeleteItem(item.ID) {
+		t.Fatal("删除失败")
+	}
+	if _, ok := cm.GetItem(item.ID); ok {
+		t.Fatal("删除后条目仍在列表中")
+	}
+	trash := cm.GetTrash()
+	if len(trash) != 1 || trash[0].ID != item.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("回收站内容不正确: %+v", trash)
+	}
+
+	// 回收站应随数据文件一起保存
+	cm = reload(t, cm)
+	if !cm.RestoreItem(item.ID) {
+		t.Fatal("恢复失败")
+	}
+	if cm.RestoreItem(item.ID) {
+		t.Fatal("重复恢复应返回 false")
+	}
+	restored, ok := cm.GetItem(item.ID)
+	if !ok || restored.Content != "keep me" || !restored.Pinned || restored.DeletedAt != nil {
+		t.Fatalf("恢复后的条目不正确: %+v", restored)
+	}
+	if len(cm.GetTrash()) != 0 {
+		t.Fatal("恢复后回收站应为空")
+	}
+	checkIDIndex(t, cm)
+	checkContentIndex(t, cm)
+
+	// 恢复后再次粘贴相同内容应合并而不是新建
+	if again, existed := cm.AddItem("keep me"); !existed || again.ID != item.ID {
+		t.Fatalf("恢复的内容未进入内容索引: id=%d existed=%v", again.ID, existed)
+	}
+	if _, ok := cm.GetItem(other.ID); !ok {
+		t.Fatal("其他条目不应受影响")
+	}
+}
+
+func TestTrashEvictsOldest(t *testing.T) {
+	cm := newTestManager(t)
+	var ids []int
+	for i := 0; i < maxTrashSize+5; i++ {
+		item, _ := cm.AddItem(fmt.Sprintf("item %d", i))
+		ids = append(ids, item.ID)
+	}
+	for _, id := range ids {
+		cm.DeleteItem(id)
+	}
+
+	trash := cm.GetTrash()
+	if len(trash) != maxTrashSize {
+		t.Fatalf("回收站有 %d 条，期望上限 %d 条", len(trash), maxTrashSize)
+	}
+	if trash[0].ID != ids[len(ids)-1] {
+		t.Fatalf("最近删除的条目应在最前，得到 %d", trash[0].ID)
+	}
+	for _, id := range ids[:5] {
+		if cm.RestoreItem(id) {
+			t.Fatalf("最早删除的条目 %d 应已被丢弃", id)
+		}
+	}
+	if got := len(reload(t, cm).GetTrash()); got != maxTrashSize {
+		t.Fatalf("重新加载后回收站有 %d 条", got)
+	}
+}
+
+func TestSeparatePinned(t *testing.T) {
+	for _, separate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("separate=%v", separate), func(t *testing.T) {
+			setFlag(t, separatePinned, separate)
+			cm := newTestManager(t)
+			pinned, _ := cm.AddItem("shared")
+			cm.SetPin(pinned.ID, true)
+
+			item, existed := cm.AddItem("shared")
+			if !separate {
+				// 默认：命中置顶项，只更新置顶项，不新建
+				if !existed || item.ID != pinned.ID || !item.Pinned {
+					t.Fatalf("应合并到置顶项，得到 id=%d existed=%v pinned=%v", item.ID, existed, item.Pinned)
+				}
+				if got, _ := cm.GetItem(pinned.ID); got.CopyCount != 2 {
+					t.Fatalf("置顶项 copy_count=%d，期望 2", got.CopyCount)
+				}
+				if n := len(cm.GetItems()); n != 1 {
+					t.Fatalf("不应新建条目，列表有 %d 条", n)
+				}
+				return
+			}
+
+			// -separate-pinned：新建一条非置顶副本
+			if existed || item.ID == pinned.ID || item.Pinned {
+				t.Fatalf("应新建非置顶条目，得到 id=%d existed=%v pinned=%v", item.ID, existed, item.Pinned)
+			}
+			// 再次粘贴合并到非置顶副本，置顶项保持不变
+			again, existed := cm.AddItem("shared")
+			if !existed || again.ID != item.ID {
+				t.Fatalf("再次粘贴应合并到非置顶副本 %d，得到 %d", item.ID, again.ID)
+			}
+			if got, _ := cm.GetItem(pinned.ID); got.CopyCount != 1 || !got.Pinned {
+				t.Fatalf("置顶项不应被修改: %+v", got)
+			}
+			if n := len(cm.GetItems()); n != 2 {
+				t.Fatalf("列表应有 2 条，得到 %d 条", n)
+			}
+			checkContentIndex(t, cm)
+
+			// 删除非置顶副本后再粘贴，重新新建副本
+			cm.DeleteItem(item.ID)
+			if third, existed := cm.AddItem("shared"); existed || third.Pinned {
+				t.Fatalf("删除副本后应再次新建，得到 id=%d existed=%v", third.ID, existed)
+			}
+		})
+	}
+}
+
+func TestRedundantSaveKeepsMtime(t *testing.T) {
+	cm := newTestManager(t)
+	item, _ := cm.AddItem("hello")
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	// 把修改时间调到过去，避免文件系统时间精度掩盖重写
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(cm.dataFile, past, past); err != nil {
+		t.Fatal(err)
+	}
+	mtime := func() time.Time {
+		t.Helper()
+		info, err := os.Stat(cm.dataFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime()
+	}
+
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	if !mtime().Equal(past) {
+		t.Fatal("没有修改时保存不应重写文件")
+	}
+
+	// 置顶再取消：标记为已修改，但序列化结果与磁盘相同
+	cm.TogglePin(item.ID)
+	cm.TogglePin(item.ID)
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	if !mtime().Equal(past) {
+		t.Fatal("内容与磁盘相同时保存不应重写文件")
+	}
+
+	cm.TogglePin(item.ID)
+	if err := cm.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	if mtime().Equal(past) {
+		t.Fatal("内容变化后应重写文件")
+	}
+}
+
+func TestImportOverlappingContent(t *testing.T) {
+	setFlag(t, normalizeNewlines, true)
+	useTransformers(t)
+	cm := useTestManager(t)
+	cm.AddItem("already here")
+	cm.AddItem("multi\nline")
+	pinned, _ := cm.AddItem("pinned here")
+	cm.SetPin(pinned.ID, true)
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := []ClipboardItem{
+		{ID: 100, Content: "new one", Pinned: true, CreatedAt: created},
+		{ID: 101, Content: "already here"},
+		{ID: 102, Content: "pinned here"},
+		{ID: 103, Content: "multi\r\nline"}, // 换行规范化后与已有内容相同
+		{ID: 104, Content: "new two"},
+		{ID: 105, Content: "new two"}, // 与本次导入的前一条重复
+		{ID: 106, Content: ""},
+		{ID: 107, Content: "aGk=", MimeType: "image/png\n1|true|ZXZpbA=="},
+		{ID: 108, Content: "aGk=", MimeType: "image/png"},
+		{ID: 109, Content: "not base64!", MimeType: "image/png"},
+		{ID: 110, Content: base64.StdEncoding.EncodeToString(make([]byte, maxBlobSize+1)), MimeType: "image/png"},
+		{ID: 111, Content: "long source", Source: strings.Repeat("s", maxSourceLength+10)},
+	}
+	body, err := json.Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := doRequest(handleImport, http.MethodPost, "/api/import", string(body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("导入失败: %d %s", rec.Code, rec.Body.String())
+	}
+	var resp struct{ Added, Skipped, Invalid int }
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Added != 4 || resp.Skipped != 4 || resp.Invalid != 4 {
+		t.Fatalf("added=%d skipped=%d invalid=%d，期望 4、4、4", resp.Added, resp.Skipped, resp.Invalid)
+	}
+
+	got := cm.GetItems()
+	if len(got) != 7 {
+		t.Fatalf("导入后应有 7 条，得到 %d 条", len(got))
+	}
+	// 导入的非置顶条目按原顺序排在原有条目之前
+	pos := map[string]int{}
+	for i, item := range got {
+		pos[item.Content] = i
+	}
+	if !(pos["new two"] < pos["aGk="] && pos["aGk="] < pos["multi\nline"]) {
+		t.Fatalf("导入后顺序不正确: %v", pos)
+	}
+	for _, item := range got {
+		if item.ID >= 100 {
+			t.Fatalf("导入的条目应分配新 ID，得到 %d", item.ID)
+		}
+		if item.Content == "new one" && (!item.Pinned || !item.CreatedAt.Equal(created)) {
+			t.Fatalf("应保留置顶状态和创建时间: %+v", item)
+		}
+		if item.Content == "long source" && len([]rune(item.Source)) > maxSourceLength {
+			t.Fatalf("来源应截断到 %d 个字符，得到 %d 个", maxSourceLength, len([]rune(item.Source)))
+		}
+	}
+	checkContentIndex(t, cm)
+	checkIDIndex(t, cm)
+
+	// 重复导入同一批数据不应新增
+	rec = doRequest(handleImport, http.MethodPost, "/api/import", string(body))
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Added != 0 {
+		t.Fatalf("重复导入新增了 %d 条", resp.Added)
+	}
+}
+
+func TestPinLimit(t *testing.T) {
+	setFlag(t, maxPinned, 2)
+	cm := useTestManager(t)
+	var ids []int
+	for i := 0; i < 3; i++ {
+		item, _ := cm.AddItem(fmt.Sprintf("item %d", i))
+		ids = append(ids, item.ID)
+	}
+	for _, id := range ids[:2] {
+		if ok, err := cm.TogglePin(id); !ok || err != nil {
+			t.Fatalf("置顶 %d 失败: %v", id, err)
+		}
+	}
+
+	// 达到上限后再置顶返回错误，状态不变
+	if ok, err := cm.TogglePin(ids[2]); ok || !errors.Is(err, errPinLimitReached) {
+		t.Fatalf("TogglePin 超出上限应返回 errPinLimitReached，得到 %v, %v", ok, err)
+	}
+	if _, err := cm.SetPin(ids[2], true); !errors.Is(err, errPinLimitReached) {
+		t.Fatalf("SetPin 超出上限应返回 errPinLimitReached，得到 %v", err)
+	}
+	rec := doRequest(handleSetPin, http.MethodPost, "/api/pin", fmt.Sprintf(`{"id":%d,"pinned":true}`, ids[2]))
+	if rec.Code != http.StatusConflict || decodeAPIError(t, rec).Code != "pin_limit_reached" {
+		t.Fatalf("应返回 409 pin_limit_reached，得到 %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, _ := cm.GetItem(ids[2]); got.Pinned {
+		t.Fatal("超出上限的条目不应被置顶")
+	}
+	// 已置顶的条目再次设为置顶不受上限影响
+	if ok, err := cm.SetPin(ids[0], true); !ok || err != nil {
+		t.Fatalf("已置顶条目重复置顶失败: %v", err)
+	}
+
+	// 取消一个置顶后腾出名额
+	if ok, err := cm.TogglePin(ids[0]); !ok || err != nil {
+		t.Fatalf("取消置顶失败: %v", err)
+	}
+	rec = doRequest(handleSetPin, http.MethodPost, "/api/pin", fmt.Sprintf(`{"id":%d,"pinned":true}`, ids[2]))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("腾出名额后置顶失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if got := pinnedIDs(cm); len(got) != 2 || !slices.Contains(got, ids[2]) {
+		t.Fatalf("置顶条目 %v", got)
+	}
+}
+
+// useReplaceRules 设置 -replace 规则，测试结束后恢复
+func useReplaceRules(t *testing.T, specs ...string) {
+	t.Helper()
+	var rules replaceRules
+	for _, spec := range specs {
+		if err := rules.Set(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setFlag(t, &replaceFlag, rules)
+}
+
+func TestTransformers(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T)
+		in, out string
+	}{
+		{"-trim 去除行尾空白和末尾空行", func(t *testing.T) { setFlag(t, trimTrailing, true) }, "a  \nb\t\n\n\n", "a\nb"},
+		{"-trim 保留行首缩进", func(t *testing.T) { setFlag(t, trimTrailing, true) }, "  a \n\tb", "  a\n\tb"},
+		{"-collapse-blanks 合并连续空行", func(t *testing.T) { setFlag(t, collapseBlanks, true) }, "a\n\n\n \n\nb\n\nc", "a\n\nb\n\nc"},
+		{"-replace 按参数顺序执行", func(t *testing.T) { useReplaceRules(t, "foo=>bar", "bar=>baz") }, "foo bar", "baz baz"},
+		{"-replace 支持分组引用", func(t *testing.T) { useReplaceRules(t, `(\w+)@example\.com=>$1@***`) }, "mail bob@example.com", "mail bob@***"},
+		{"-sanitize 移除控制字符", func(t *testing.T) { setFlag(t, sanitize, true) }, "a\x00b\x07\tc", "ab\tc"},
+		{"-normalize-newlines 统一换行", func(t *testing.T) { setFlag(t, normalizeNewlines, true) }, "a\r\nb", "a\nb"},
+		{"未开启时不处理", func(t *testing.T) {}, "a  \n\n\n", "a  \n\n\n"},
+		{
+			// 替换产生的空行和行尾空格会被后续的合并和去空白处理
+			"组合顺序：替换后再整理空白",
+			func(t *testing.T) {
+				setFlag(t, trimTrailing, true)
+				setFlag(t, collapseBlanks, true)
+				useReplaceRules(t, "SECRET=>")
+			},
+			"a\nSECRET\n\nSECRET\nb SECRET",
+			"a\n\nb",
+		},
+		{
+			// 先规范化换行，-replace 规则只需要匹配 \n
+			"组合顺序：规范化换行先于替换",
+			func(t *testing.T) {
+				setFlag(t, normalizeNewlines, true)
+				useReplaceRules(t, `\n=>;`)
+			},
+			"a\r\nb",
+			"a;b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, &replaceFlag, nil)
+			tt.setup(t)
+			useTransformers(t)
+			if got := normalizeText(tt.in); got != tt.out {
+				t.Fatalf("normalizeText(%q) = %q，期望 %q", tt.in, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestEmptyAfterTransformIsRejected(t *testing.T) {
+	setFlag(t, trimTrailing, true)
+	setFlag(t, sanitize, true)
+	useTransformers(t)
+	cm := useTestManager(t)
+
+	for _, content := range []string{"   \n  ", "\x00"} {
+		body, _ := json.Marshal(map[string]string{"content": content})
+		rec := doRequest(handleAdd, http.MethodPost, "/api/add", string(body))
+		if rec.Code != http.StatusBadRequest || decodeAPIError(t, rec).Code != "empty_content" {
+			t.Errorf("/api/add %q: 应返回 400 empty_content，得到 %d: %s", content, rec.Code, rec.Body.String())
+		}
+
+		rec = doRequest(handleQuickAdd, http.MethodGet, "/api/quick-add?content="+url.QueryEscape(content), "")
+		if rec.Code != http.StatusBadRequest || decodeAPIError(t, rec).Code != "empty_content" {
+			t.Errorf("/api/quick-add %q: 应返回 400 empty_content，得到 %d: %s", content, rec.Code, rec.Body.String())
+		}
+
+		handleWebSocketMessage(httptest.NewRequest(http.MethodGet, "/ws", nil), cm, nil, wsMessage{Action: "add", Content: content})
+
+		if _, _, err := cm.AddItemFrom(content, "", ""); !errors.Is(err, errContentEmpty) {
+			t.Errorf("AddItemFrom(%q) 应返回 errContentEmpty，得到 %v", content, err)
+		}
+	}
+	if items := cm.GetItems(); len(items) != 0 {
+		t.Fatalf("不应添加空条目，得到 %+v", items)
+	}
+}
+
+// testCA 测试用的内存 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issueClientCert 签发一张客户端证书
+func (ca *testCA) issueClientCert(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	ca := newTestCA(t, "easyCopy test CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := loadClientCAs(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := generateSelfSignedCert("easyCopy", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(handlePing))
+	srv.TLS = newTLSConfig(serverCert, pool)
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			// 这里只验证客户端证书，服务端证书是自签名的
+			TLSClientConfig: &tls.Config{Certificates: certs, InsecureSkipVerify: true},
+		}}
+		resp, err := client.Get(srv.URL + "/api/ping")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("状态码 %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	if err := get(ca.issueClientCert(t, "alice")); err != nil {
+		t.Fatalf("持有受信任 CA 签发的证书应能连接: %v", err)
+	}
+	if err := get(); err == nil {
+		t.Fatal("未出示客户端证书的连接应被拒绝")
+	}
+	other := newTestCA(t, "other CA")
+	if err := get(other.issueClientCert(t, "mallory")); err == nil {
+		t.Fatal("其他 CA 签发的证书应被拒绝")
+	}
+}
+
+func TestLoadClientCAsRejectsNonPEM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadClientCAs(path); err == nil {
+		t.Fatal("不含 PEM 证书的文件应返回错误")
+	}
+	if _, err := loadClientCAs(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("文件不存在时应返回错误")
+	}
+}
+
+func TestStripTrackingParams(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"https://a.com/p?utm_source=x&id=1&utm_medium=y", "https://a.com/p?id=1"},
+		{"https://a.com/p?b=2&fbclid=abc&a=1", "https://a.com/p?b=2&a=1"},
+		{"https://a.com/p?UTM_Source=x", "https://a.com/p"},
+		{"https://a.com/p?utm_source=x#frag", "https://a.com/p#frag"},
+		{"https://a.com/p?q=a%20b&gclid=1", "https://a.com/p?q=a%20b"},
+		{"https://a.com/p?id=1", "https://a.com/p?id=1"},
+		{"see https://a.com/?utm_id=1 and http://b.org/x?_ga=2&k=v", "see https://a.com/ and http://b.org/x?k=v"},
+		{"no links here", "no links here"},
+	}
+	for _, tt := range tests {
+		if got := stripTrackingParams(tt.in); got != tt.out {
+			t.Errorf("stripTrackingParams(%q) = %q，期望 %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestTransformHandler(t *testing.T) {
+	cm := useTestManager(t)
+	text, _ := cm.AddItem("  Visit https://a.com/?utm_source=x&id=1  ")
+	blob, _ := cm.AddItemWithMime("aGk=", "image/png")
+
+	type result struct {
+		ID      int    `json:"id"`
+		Op      string `json:"op"`
+		Content string `json:"content"`
+		Changed bool   `json:"changed"`
+	}
+	tests := []struct {
+		op, want string
+	}{
+		{"strip-tracking", "  Visit https://a.com/?id=1  "},
+		{"trim", "Visit https://a.com/?utm_source=x&id=1"},
+		{"lower", "  visit https://a.com/?utm_source=x&id=1  "},
+		{"upper", "  VISIT HTTPS://A.COM/?UTM_SOURCE=X&ID=1  "},
+	}
+	for _, tt := range tests {
+		rec := doRequest(handleTransform, http.MethodGet, fmt.Sprintf("/api/transform?id=%d&op=%s", text.ID, tt.op), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: 状态码 %d: %s", tt.op, rec.Code, rec.Body.String())
+		}
+		var res result
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.ID != text.ID || res.Op != tt.op || res.Content != tt.want || !res.Changed {
+			t.Errorf("%s: 得到 %+v，期望内容 %q", tt.op, res, tt.want)
+		}
+	}
+	if got, _ := cm.GetItem(text.ID); got.Content != text.Content {
+		t.Fatalf("transform 不应修改已保存的条目，得到 %q", got.Content)
+	}
+
+	// 内容不变时 changed 为 false
+	plain, _ := cm.AddItem("plain")
+	rec := doRequest(handleTransform, http.MethodGet, fmt.Sprintf("/api/transform?id=%d&op=strip-tracking", plain.ID), "")
+	if !strings.Contains(rec.Body.String(), `"changed":false`) {
+		t.Fatalf("内容不变时 changed 应为 false: %s", rec.Body.String())
+	}
+
+	errorCases := []struct {
+		target string
+		status int
+		code   string
+	}{
+		{fmt.Sprintf("/api/transform?id=%d&op=reverse", text.ID), http.StatusBadRequest, "invalid_op"},
+		{"/api/transform?id=999&op=trim", http.StatusNotFound, "not_found"},
+		{"/api/transform?id=abc&op=trim", http.StatusBadRequest, "invalid_id"},
+		{fmt.Sprintf("/api/transform?id=%d&op=trim", blob.ID), http.StatusBadRequest, "unsupported_item"},
+	}
+	for _, tc := range errorCases {
+		rec := doRequest(handleTransform, http.MethodGet, tc.target, "")
+		if rec.Code != tc.status || decodeAPIError(t, rec).Code != tc.code {
+			t.Errorf("%s: 得到 %d %s，期望 %d %s", tc.target, rec.Code, rec.Body.String(), tc.status, tc.code)
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	cm := useTestManager(t)
+	if groups := cm.FindDuplicates(); groups == nil || len(groups) != 0 {
+		t.Fatalf("没有重复时应返回空切片，得到 %#v", groups)
+	}
+
+	a1, _ := cm.AddItem("alpha")
+	b1, _ := cm.AddItem("beta")
+	a2, _ := cm.AddItem("tmp1")
+	a3, _ := cm.AddItem("tmp2")
+	b2, _ := cm.AddItem("tmp3")
+	unique, _ := cm.AddItem("unique")
+	blob, _ := cm.AddItemWithMime("YWxwaGE=", "image/png")
+	text, _ := cm.AddItem("YWxwaGE=") // 与二进制条目的 base64 相同，但类型不同，不算重复
+	// 通过编辑制造重复：a1/a2/a3 都是 alpha，b1/b2 都是 beta
+	cm.UpdateItem(a2.ID, "alpha")
+	cm.UpdateItem(a3.ID, "alpha")
+	cm.UpdateItem(b2.ID, "beta")
+
+	groups := cm.FindDuplicates()
+	got := map[string][]int{}
+	for _, group := range groups {
+		for _, item := range group {
+			got[group[0].Content] = append(got[group[0].Content], item.ID)
+		}
+	}
+	// 列表中越新的条目越靠前，每组第一条即 Compact 保留的条目
+	want := map[string][]int{
+		"alpha": {a3.ID, a2.ID, a1.ID},
+		"beta":  {b2.ID, b1.ID},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("得到 %d 组重复，期望 %d 组: %v", len(groups), len(want), got)
+	}
+	for content, ids := range want {
+		if !slices.Equal(got[content], ids) {
+			t.Errorf("%s 组为 %v，期望 %v", content, got[content], ids)
+		}
+	}
+
+	rec := doRequest(handleDuplicates, http.MethodGet, "/api/duplicates", "")
+	var resp struct {
+		Groups    [][]ClipboardItem `json:"groups"`
+		Removable int               `json:"removable"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Groups) != 2 || resp.Removable != 3 {
+		t.Fatalf("接口返回 %d 组，可删除 %d 条，期望 2 组和 3 条", len(resp.Groups), resp.Removable)
+	}
+
+	// FindDuplicates 不修改数据，Compact 删除的正是每组除第一条外的条目
+	if n := len(cm.GetItems()); n != 8 {
+		t.Fatalf("FindDuplicates 不应修改列表，得到 %d 条", n)
+	}
+	if removed := cm.Compact(); removed != resp.Removable {
+		t.Fatalf("Compact 删除 %d 条，与 removable=%d 不一致", removed, resp.Removable)
+	}
+	for _, id := range []int{a3.ID, b2.ID, unique.ID, blob.ID, text.ID} {
+		if _, ok := cm.GetItem(id); !ok {
+			t.Errorf("条目 %d 应被保留", id)
+		}
+	}
+	if groups := cm.FindDuplicates(); len(groups) != 0 {
+		t.Fatalf("Compact 之后不应再有重复: %v", groups)
+	}
+}
+
+func TestResolveListenAddr(t *testing.T) {
+	tests := []struct {
+		name       string
+		flagValue  string
+		env        map[string]string
+		addr, from string
+	}{
+		{"参数优先", "127.0.0.1:9000", map[string]string{"EASYCOPY_ADDR": ":7000", "PORT": "6000"}, "127.0.0.1:9000", "-addr 参数"},
+		{"EASYCOPY_ADDR 优先于 PORT", "", map[string]string{"EASYCOPY_ADDR": "0.0.0.0:7000", "PORT": "6000"}, "0.0.0.0:7000", "环境变量 EASYCOPY_ADDR"},
+		{"PORT 监听所有网卡", "", map[string]string{"PORT": "6000"}, ":6000", "环境变量 PORT"},
+		{"空环境变量视为未设置", "", map[string]string{"EASYCOPY_ADDR": "", "PORT": ""}, defaultListenAddr, "默认值"},
+		{"默认值", "", nil, ":8084", "默认值"},
+	}
+	for _, tt := range tests {
+		addr, from := resolveListenAddr(tt.flagValue, func(key string) string { return tt.env[key] })
+		if addr != tt.addr || from != tt.from {
+			t.Errorf("%s: 得到 %q（%s），期望 %q（%s）", tt.name, addr, from, tt.addr, tt.from)
+		}
+	}
+}
+
+func TestJSONLinesExportRoundTrip(t *testing.T) {
+	cm := useTestManager(t)
+	for i := 0; i < jsonlFlushInterval*2+5; i++ {
+		cm.AddItem(fmt.Sprintf("item %d\nwith newline", i))
+	}
+	pinned, _ := cm.AddItem("pinned")
+	cm.SetPin(pinned.ID, true)
+	archived, _ := cm.AddItem("archived")
+	cm.ToggleArchive(archived.ID)
+	want := cm.ExportItems()
+
+	srv := httptest.NewServer(http.HandlerFunc(handleExport))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/export?format=jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var got []ClipboardItem
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var item ClipboardItem
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			t.Fatalf("第 %d 行不是合法的 JSON: %v", len(got)+1, err)
+		}
+		got = append(got, item)
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("读回 %d 条，期望 %d 条", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].Content != want[i].Content || got[i].Pinned != want[i].Pinned || got[i].Archived != want[i].Archived {
+			t.Fatalf("第 %d 条为 %+v，期望 %+v", i, got[i], want[i])
+		}
+	}
+
+	// 导出的 JSON Lines 可以直接导入另一个实例
+	other := useTestManager(t)
+	rec := doRequest(handleImport, http.MethodPost, "/api/import?format=jsonl", strings.Join(lines, "\n")+"\n")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("导入失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), fmt.Sprintf(`"added":%d`, len(want))) {
+		t.Fatalf("应全部导入: %s", rec.Body.String())
+	}
+	imported := other.ExportItems()
+	for i := range want {
+		if imported[i].Content != want[i].Content || imported[i].Pinned != want[i].Pinned || imported[i].Archived != want[i].Archived {
+			t.Fatalf("导入后第 %d 条为 %+v，期望 %+v", i, imported[i], want[i])
+		}
+	}
+}
+
+func TestJSONLinesImportErrors(t *testing.T) {
+	useTestManager(t)
+	rec := doRequest(handleImport, http.MethodPost, "/api/import?format=jsonl", "{\"content\":\"a\"}\nnot json\n")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "record 2") {
+		t.Fatalf("第二行出错时应返回 400 并指出记录序号，得到 %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(handleImport, http.MethodPost, "/api/import?format=xml", "")
+	if rec.Code != http.StatusBadRequest || decodeAPIError(t, rec).Code != "invalid_format" {
+		t.Fatalf("未知格式应返回 400 invalid_format，得到 %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAddMergeUpdatesFields(t *testing.T) {
+	cm := useTestManager(t)
+	add := func(body string) map[string]any {
+		t.Helper()
+		rec := doRequest(handleAdd, http.MethodPost, "/api/add", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("添加失败: %d %s", rec.Code, rec.Body.String())
+		}
+		var resp map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	first := add(`{"content":"shared","source":"laptop"}`)
+	id := int(first["id"].(float64))
+	if first["existed"] != false || first["copy_count"] != float64(1) {
+		t.Fatalf("首次添加: %v", first)
+	}
+	cm.SetPin(id, true)
+	before, _ := cm.GetItem(id)
+	time.Sleep(10 * time.Millisecond)
+
+	second := add(`{"content":"shared","source":"phone"}`)
+	if int(second["id"].(float64)) != id || second["existed"] != true || second["copy_count"] != float64(2) || second["pinned"] != true {
+		t.Fatalf("合并后的响应: %v", second)
+	}
+	after, _ := cm.GetItem(id)
+	if !after.LastSeen.After(before.LastSeen) {
+		t.Errorf("LastSeen 应更新: %v -> %v", before.LastSeen, after.LastSeen)
+	}
+	if after.CopyCount != before.CopyCount+1 {
+		t.Errorf("CopyCount = %d，期望 %d", after.CopyCount, before.CopyCount+1)
+	}
+	if !after.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("CreatedAt 应保持不变: %v -> %v", before.CreatedAt, after.CreatedAt)
+	}
+	if !after.Pinned {
+		t.Error("置顶状态应保持")
+	}
+	if after.Source != "phone" {
+		t.Errorf("Source = %q，期望更新为 phone", after.Source)
+	}
+	if len(cm.GetItems()) != 1 {
+		t.Fatal("合并不应新建条目")
+	}
+}
+
+func TestOpenAPIAddResponseMatchesHandler(t *testing.T) {
+	useTestManager(t)
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Responses map[string]struct {
+				Content map[string]struct {
+					Schema struct {
+						Properties map[string]any `json:"properties"`
+					} `json:"schema"`
+				} `json:"content"`
+			} `json:"responses"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(openAPIDocument), &doc); err != nil {
+		t.Fatalf("openAPIDocument 不是合法的 JSON: %v", err)
+	}
+	documented := doc.Paths["/api/add"]["post"].Responses["200"].Content["application/json"].Schema.Properties
+
+	rec := doRequest(handleAdd, http.MethodPost, "/api/add", `{"content":"x"}`)
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for key := range resp {
+		if _, ok := documented[key]; !ok {
+			t.Errorf("响应字段 %q 未写入 OpenAPI 文档", key)
+		}
+	}
+	for key := range documented {
+		if _, ok := resp[key]; !ok {
+			t.Errorf("OpenAPI 文档中的字段 %q 不在响应中", key)
+		}
+	}
+}
+
+func TestAppendIsCappedByMaxBody(t *testing.T) {
+	setFlag(t, maxBodySize, int64(1024))
+	cm := useTestManager(t)
+	item, _ := cm.AddItem("start")
+
+	chunk := strings.Repeat("x", 100)
+	appended := 0
+	for i := 0; i < 20; i++ {
+		ok, err := cm.AppendToItem(item.ID, chunk, "\n")
+		if errors.Is(err, errAppendTooLarge) {
+			break
+		}
+		if !ok || err != nil {
+			t.Fatalf("追加失败: %v", err)
+		}
+		appended++
+	}
+	got, _ := cm.GetItem(item.ID)
+	if appended == 0 || appended == 20 || int64(len(got.Content)) > *maxBodySize {
+		t.Fatalf("追加 %d 次后内容 %d 字节，应在超过 %d 字节前被拒绝", appended, len(got.Content), *maxBodySize)
+	}
+
+	rec := doRequest(handleAppend, http.MethodPost, "/api/append", fmt.Sprintf(`{"id":%d,"content":%q}`, item.ID, chunk))
+	if rec.Code != http.StatusRequestEntityTooLarge || decodeAPIError(t, rec).Code != "content_too_large" {
+		t.Fatalf("应返回 413 content_too_large，得到 %d: %s", rec.Code, rec.Body.String())
+	}
+	if after, _ := cm.GetItem(item.ID); after.Content != got.Content {
+		t.Fatal("被拒绝的追加不应修改内容")
+	}
+	if loaded, ok := reload(t, cm).GetItem(item.ID); !ok || loaded.Content != got.Content {
+		t.Fatal("追加后的条目应能重新读回")
+	}
+}
+
+func TestUpdateRunsTransformers(t *testing.T) {
+	setFlag(t, sanitize, true)
+	setFlag(t, normalizeNewlines, true)
+	useTransformers(t)
+	cm := useTestManager(t)
+	item, _ := cm.AddItem("old")
+
+	if ok, err := cm.UpdateItem(item.ID, "a\x00b\r\nc"); !ok || err != nil {
+		t.Fatalf("修改失败: %v", err)
+	}
+	if got, _ := cm.GetItem(item.ID); got.Content != "ab\nc" {
+		t.Fatalf("修改后的内容应经过处理，得到 %q", got.Content)
+	}
+	// 处理后的内容能被之后的去重命中
+	if again, existed := cm.AddItem("ab\r\nc"); !existed || again.ID != item.ID {
+		t.Fatalf("修改后的内容未被去重命中: id=%d existed=%v", again.ID, existed)
+	}
+
+	if _, err := cm.UpdateItem(item.ID, "\x00"); !errors.Is(err, errContentEmpty) {
+		t.Fatalf("处理后为空应返回 errContentEmpty，得到 %v", err)
+	}
+	rec := doRequest(handleUpdate, http.MethodPost, "/api/update", fmt.Sprintf(`{"id":%d,"content":"\u0000"}`, item.ID))
+	if rec.Code != http.StatusBadRequest || decodeAPIError(t, rec).Code != "empty_content" {
+		t.Fatalf("应返回 400 empty_content，得到 %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, _ := cm.GetItem(item.ID); got.Content != "ab\nc" {
+		t.Fatalf("被拒绝的修改不应生效，得到 %q", got.Content)
+	}
+}
+
+func TestAppendRunsTransformers(t *testing.T) {
+	setFlag(t, sanitize, true)
+	setFlag(t, normalizeNewlines, true)
+	useTransformers(t)
+	cm := useTestManager(t)
+	item, _ := cm.AddItem("a")
+
+	if ok, err := cm.AppendToItem(item.ID, "b\x00\r\nc", "\r\n"); !ok || err != nil {
+		t.Fatalf("追加失败: %v", err)
+	}
+	if got, _ := cm.GetItem(item.ID); got.Content != "a\nb\nc" {
+		t.Fatalf("追加的内容和分隔符应经过处理，得到 %q", got.Content)
+	}
+	if _, err := cm.AppendToItem(item.ID, "\x07", ""); !errors.Is(err, errContentEmpty) {
+		t.Fatalf("处理后为空应返回 errContentEmpty，得到 %v", err)
+	}
+	rec := doRequest(handleAppend, http.MethodPost, "/api/append", fmt.Sprintf(`{"id":%d,"content":"\u0007"}`, item.ID))
+	if rec.Code != http.StatusBadRequest || decodeAPIError(t, rec).Code != "empty_content" {
+		t.Fatalf("应返回 400 empty_content，得到 %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, _ := cm.GetItem(item.ID); got.Content != "a\nb\nc" {
+		t.Fatalf("被拒绝的追加不应生效，得到 %q", got.Content)
+	}
+	if again, existed := cm.AddItem("a\r\nb\r\nc"); !existed || again.ID != item.ID {
+		t.Fatalf("追加后的内容未被去重命中: id=%d existed=%v", again.ID, existed)
+	}
+}
+
+func TestItemsConditionalGet(t *testing.T) {
+	cm := useTestManager(t)
+	item, _ := cm.AddItem("a")
+	cm.AddItem("b")
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handleItems(rec, req)
+		return rec
+	}
+
+	// 把最近变更时间调到过去，之后的变更应让 Last-Modified 前进
+	past := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	cm.mu.Lock()
+	cm.modifiedAt = past
+	cm.mu.Unlock()
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("首次请求: %d, ETag=%q", first.Code, etag)
+	}
+	if lm := first.Header().Get("Last-Modified"); lm != past.Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified = %q，期望 %q", lm, past.Format(http.TimeFormat))
+	}
+
+	cached := get(etag)
+	if cached.Code != http.StatusNotModified || cached.Body.Len() != 0 {
+		t.Fatalf("重放 ETag 应返回空的 304，得到 %d: %q", cached.Code, cached.Body.String())
+	}
+
+	// 删除、置顶、归档都不改变 LastSeen，但都应更新 ETag 和 Last-Modified
+	mutations := []struct {
+		name   string
+		mutate func()
+	}{
+		{"置顶", func() { cm.TogglePin(item.ID) }},
+		{"归档", func() { cm.ToggleArchive(item.ID) }},
+		{"删除", func() { cm.DeleteItem(item.ID) }},
+	}
+	for _, m := range mutations {
+		name := m.name
+		m.mutate()
+		rec := get(etag)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s后重放旧 ETag 应返回 200，得到 %d", name, rec.Code)
+		}
+		newTag := rec.Header().Get("ETag")
+		if newTag == etag {
+			t.Fatalf("%s后 ETag 应变化", name)
+		}
+		lm, err := http.ParseTime(rec.Header().Get("Last-Modified"))
+		if err != nil || !lm.After(past) {
+			t.Fatalf("%s后 Last-Modified 应前进，得到 %v (%v)", name, lm, err)
+		}
+		etag = newTag
+	}
+}
+
+// blockingWriter 第一次写入时通知 started 并阻塞到 release 关闭，模拟慢速客户端
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestJSONLinesExportDoesNotHoldLock(t *testing.T) {
+	cm := newTestManager(t)
+	for i := 0; i < 10; i++ {
+		cm.AddItem(fmt.Sprintf("item %d", i))
+	}
+	w := &blockingWriter{ResponseRecorder: httptest.NewRecorder(), started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		exportJSONLines(w, cm)
+		close(done)
+	}()
+	<-w.started
+
+	added := make(chan struct{})
+	go func() {
+		cm.AddItem("while exporting")
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(2 * time.Second):
+		close(w.release)
+		t.Fatal("导出写出期间添加条目被阻塞")
+	}
+	close(w.release)
+	<-done
+	if n := strings.Count(w.Body.String(), "\n"); n != 10 {
+		t.Fatalf("导出 %d 行，期望开始导出时的 10 条", n)
+	}
+}
+
+func TestJSONLinesImportRequiresNDJSONWithAuth(t *testing.T) {
+	cm := useTestManager(t)
+	setFlag(t, authUser, "alice")
+	body := "{\"content\":\"a\"}\n"
+
+	for _, tc := range []struct {
+		contentType string
+		want        int
+	}{
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+		{"application/x-ndjson", http.StatusOK},
+		{"application/json; charset=utf-8", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/import?format=jsonl", strings.NewReader(body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		handleImport(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("Content-Type %q: 状态码 %d，期望 %d: %s", tc.contentType, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+	if n := len(cm.GetItems()); n != 1 {
+		t.Fatalf("只有合法请求应导入，得到 %d 条", n)
+	}
+}
+
+func TestParseRecordFieldCount(t *testing.T) {
+	cm := newTestManager(t)
+	item, _ := cm.AddItem("a")
+	data, err := cm.serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	line := lines[len(lines)-1]
+	if n := strings.Count(line, "|") + 1; n != recordFieldCount {
+		t.Fatalf("序列化的记录有 %d 个字段，recordFieldCount 为 %d", n, recordFieldCount)
+	}
+	if got, err := parseRecord(line); err != nil || got.ID != item.ID {
+		t.Fatalf("parseRecord(%q) = %+v, %v", line, got, err)
+	}
+	if _, err := parseRecord(line + "|extra"); err == nil {
+		t.Fatal("字段过多的记录应解析失败")
+	}
+}
+
+func TestAddPathsRecordClientIP(t *testing.T) {
+	cm := useTestManager(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/quick-add?content=quick", nil)
+	req.RemoteAddr = "192.0.2.10:5000"
+	rec := httptest.NewRecorder()
+	handleQuickAdd(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("quick-add 失败: %d %s", rec.Code, rec.Body.String())
+	}
+
+	wsReq := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	wsReq.RemoteAddr = "192.0.2.20:6000"
+	handleWebSocketMessage(wsReq, cm, nil, wsMessage{Action: "add", Content: "ws"})
+
+	apiReq := httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(`{"content":"api"}`))
+	apiReq.RemoteAddr = "192.0.2.30:7000"
+	rec = httptest.NewRecorder()
+	handleAdd(rec, apiReq)
+
+	want := map[string]string{"quick": "192.0.2.10", "ws": "192.0.2.20", "api": "192.0.2.30"}
+	for _, item := range cm.GetItems() {
+		if item.Source != want[item.Content] {
+			t.Errorf("%q 的来源为 %q，期望 %q", item.Content, item.Source, want[item.Content])
+		}
+	}
+	if n := len(cm.GetItems()); n != len(want) {
+		t.Fatalf("应添加 %d 条，得到 %d 条", len(want), n)
+	}
+}